@@ -0,0 +1,252 @@
+// Package chunk models the chunks of Java Edition worlds, as they are stored in
+// region files, in each of the formats they have had:
+//
+//   - Legacy, before 1.13, with numeric block IDs in Blocks, Add and Data
+//     arrays.
+//   - Flattened, from 1.13 to 1.17, with each section's block states in a
+//     Palette and BlockStates under Level.
+//   - Modern, from 1.18, with sections at the root that have block_states and
+//     biomes palettes.
+//
+// The models are decoded and encoded with the nbt package, and keep the
+// entries they have no fields for in Other, so a chunk that is decoded, edited
+// and encoded again loses nothing. Unmarshal picks the model from a chunk's
+// data version:
+//
+//	data, compression, err := r.ReadChunk(x, z)
+//	...
+//	c, err := chunk.Unmarshal(compression, data)
+//	...
+//	if c, ok := c.(*chunk.Modern); ok {
+//		c.SetBlock(0, 64, 0, chunk.Block{Name: "minecraft:gold_block"})
+//	}
+//
+// Editing blocks does not update lighting, heightmaps or anything else derived
+// from them.
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+// The data versions the format changed at.
+const (
+	// 17w47a, the first 1.13 snapshot, which replaced block IDs with
+	// palettes of block states.
+	Version113 = 1451
+
+	// 20w17a, a 1.16 snapshot, after which packed block states no longer
+	// span two longs.
+	Version116 = 2529
+
+	// 21w43a, a 1.18 snapshot, which moved the sections to the root of the
+	// chunk and gave them biomes.
+	Version118 = 2844
+)
+
+// A Chunk is a *Legacy, *Flattened or *Modern.
+type Chunk interface {
+	nbt.Marshaler
+	nbt.Unmarshaler
+
+	// Returns the chunk's coordinates, in chunks.
+	Position() (x, z int32)
+}
+
+// Decodes a chunk with the model for its data version.
+func Unmarshal(compression nbt.Compression, data []byte) (Chunk, error) {
+	var root map[string]nbt.RawTag
+	err := nbt.Unmarshal(compression, bytes.NewReader(data), &root)
+	if err != nil {
+		return nil, err
+	}
+	var version int32
+	if raw, ok := root["DataVersion"]; ok {
+		if err := raw.Unmarshal(&version); err != nil {
+			return nil, fmt.Errorf("chunk: DataVersion: %v", err)
+		}
+	}
+
+	var c Chunk
+	switch {
+	case version >= Version118:
+		m := new(Modern)
+		c, err = m, decodeEntries(m, root, &m.Other)
+	case version >= Version113:
+		f := new(Flattened)
+		c, err = f, decodeEntries(f, root, &f.Other)
+	default:
+		l := new(Legacy)
+		c, err = l, decodeEntries(l, root, &l.Other)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// A Block is a block state: the name of a block, like minecraft:oak_stairs,
+// and its properties, like facing=north.
+type Block struct {
+	Name       string
+	Properties map[string]string
+}
+
+// The block missing sections are full of.
+var air = Block{Name: "minecraft:air"}
+
+func (b *Block) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, b, nil)
+}
+
+func (b *Block) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, b, nil)
+}
+
+func (b Block) String() string {
+	if len(b.Properties) == 0 {
+		return b.Name
+	}
+	props := make([]string, 0, len(b.Properties))
+	for k, v := range b.Properties {
+		props = append(props, k+"="+v)
+	}
+	sort.Strings(props)
+	return b.Name + "[" + strings.Join(props, ",") + "]"
+}
+
+// Reports whether two blocks are the same block state.
+func (b Block) Equal(o Block) bool {
+	if b.Name != o.Name || len(b.Properties) != len(o.Properties) {
+		return false
+	}
+	for k, v := range b.Properties {
+		if ov, ok := o.Properties[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Decodes the entries of a compound, already read, into the fields of v, a
+// pointer to a struct, and keeps the ones it has no field for in *other.
+func decodeEntries(v interface{}, entries map[string]nbt.RawTag, other *map[string]nbt.RawTag) error {
+	s := reflect.ValueOf(v).Elem()
+	fields := fieldsOf(s.Type())
+	for name, raw := range entries {
+		f, ok := fields.byName[name]
+		if !ok {
+			if *other == nil {
+				*other = make(map[string]nbt.RawTag)
+			}
+			(*other)[name] = raw
+			continue
+		}
+		if err := raw.Unmarshal(s.Field(f.index).Addr().Interface()); err != nil {
+			return fmt.Errorf("chunk: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Decodes a compound into the fields of v, a pointer to a struct, as
+// Unmarshal would, and keeps the entries it has no field for in *other. If
+// other is nil, they are errors.
+func readCompound(r *nbt.Reader, v interface{}, other *map[string]nbt.RawTag) error {
+	s := reflect.ValueOf(v).Elem()
+	fields := fieldsOf(s.Type())
+	for {
+		name, tag := r.Entry()
+		if tag == nbt.TagEnd {
+			return nil
+		}
+		if f, ok := fields.byName[name]; ok {
+			r.Value(tag, s.Field(f.index).Addr().Interface(), f.opts)
+			continue
+		}
+		if other == nil {
+			r.Unhandled(tag)
+		}
+		if *other == nil {
+			*other = make(map[string]nbt.RawTag)
+		}
+		var raw nbt.RawTag
+		r.Value(tag, &raw, "")
+		(*other)[name] = raw
+	}
+}
+
+// Encodes the fields of v, a pointer to a struct, as Marshal would, followed by
+// the other entries in order of name. Fields holding nil slices, maps and
+// pointers are left out, as they are for entries a chunk doesn't have.
+func writeCompound(w *nbt.Writer, v interface{}, other map[string]nbt.RawTag) error {
+	s := reflect.ValueOf(v).Elem()
+	for _, f := range fieldsOf(s.Type()).list {
+		field := s.Field(f.index)
+		switch field.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Ptr:
+			if field.IsNil() {
+				continue
+			}
+		}
+		w.Value(f.name, field.Interface(), f.opts)
+	}
+
+	names := make([]string, 0, len(other))
+	for name := range other {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Value(name, other[name], "")
+	}
+	return nil
+}
+
+type field struct {
+	name  string
+	index int
+	opts  string
+}
+
+type structFields struct {
+	list   []field // In declaration order.
+	byName map[string]field
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// Returns the fields of a struct type that are encoded, named and with options
+// as the nbt package would give them.
+func fieldsOf(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{byName: make(map[string]field)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Anonymous {
+			continue
+		}
+		name, opts := nbt.ParseStructTag(f.Tag.Get("nbt"))
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields.byName[name] = field{name, i, opts}
+		fields.list = append(fields.list, fields.byName[name])
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+}
@@ -0,0 +1,241 @@
+package chunk
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+func TestPacked(t *testing.T) {
+	// With 5 bits, number 12 starts at bit 60 and either spans two longs or
+	// starts the second.
+	spanning := packed{data: make([]int64, 2), bits: 5, spanning: true}
+	spanning.set(12, 0x15)
+	if spanning.data[0] != 0x5<<60 || spanning.data[1] != 1 {
+		t.Errorf("Spanning packing gave %x", spanning.data)
+	}
+	padded := packed{data: make([]int64, 2), bits: 5}
+	padded.set(12, 0x15)
+	if padded.data[0] != 0 || padded.data[1] != 0x15 {
+		t.Errorf("Padded packing gave %x", padded.data)
+	}
+	if spanning.get(12) != 0x15 || padded.get(12) != 0x15 || padded.get(11) != 0 {
+		t.Error("Packed numbers read back wrong")
+	}
+	if n := spanning.longs(sectionVolume); n != 320 {
+		t.Errorf("Spanning packing needs %d longs", n)
+	}
+	if n := padded.longs(sectionVolume); n != 342 {
+		t.Errorf("Padded packing needs %d longs", n)
+	}
+}
+
+// Encodes a chunk document and decodes it as a Chunk.
+func roundTrip(t *testing.T, v interface{}) Chunk {
+	var buf bytes.Buffer
+	if err := nbt.Marshal(nbt.ZLib, &buf, v); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Unmarshal(nbt.ZLib, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Encodes a chunk and decodes it generically.
+func tree(t *testing.T, c Chunk) map[string]interface{} {
+	data, err := nbt.AppendMarshal(nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root map[string]interface{}
+	if _, err := nbt.UnmarshalBytes(data, &root); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestLegacy(t *testing.T) {
+	blocks := make([]byte, sectionVolume)
+	blocks[blockIndex(1, 2, 3)] = 4
+	c := roundTrip(t, map[string]interface{}{
+		"Level": map[string]interface{}{
+			"xPos":       int32(-3),
+			"zPos":       int32(7),
+			"LastUpdate": int64(100),
+			"Entities":   []interface{}{},
+			"Sections": []interface{}{map[string]interface{}{
+				"Y":          int8(0),
+				"Blocks":     blocks,
+				"Data":       make([]byte, sectionVolume/2),
+				"BlockLight": make([]byte, sectionVolume/2),
+				"SkyLight":   make([]byte, sectionVolume/2),
+			}},
+		},
+	})
+	legacy, ok := c.(*Legacy)
+	if !ok {
+		t.Fatalf("Decoded a %T", c)
+	}
+	if x, z := legacy.Position(); x != -3 || z != 7 {
+		t.Errorf("Position is %d, %d", x, z)
+	}
+	if b := legacy.BlockAt(1, 2, 3); b != (LegacyBlock{4, 0}) {
+		t.Errorf("Block is %+v", b)
+	}
+
+	// World coordinates, an ID that needs Add and a new section.
+	legacy.SetBlock(-15, 2, 3, LegacyBlock{300, 5})
+	legacy.SetBlock(0, 40, 0, LegacyBlock{1, 2})
+	legacy.SetBlock(0, -10, 0, LegacyBlock{1, 0})
+	if b := legacy.BlockAt(1, 2, 3); b != (LegacyBlock{300, 5}) {
+		t.Errorf("Block is %+v after setting it", b)
+	}
+	if b := legacy.BlockAt(0, 40, 0); b != (LegacyBlock{1, 2}) {
+		t.Errorf("Block in a new section is %+v", b)
+	}
+
+	root := tree(t, legacy)
+	level := root["Level"].(map[string]interface{})
+	if level["LastUpdate"] != int64(100) || level["Entities"] == nil {
+		t.Errorf("Lost entries: %v", level)
+	}
+	sections := level["Sections"].([]interface{})
+	if len(sections) != 3 || sections[1].(map[string]interface{})["Add"] == nil {
+		t.Errorf("Encoded sections %v", sections)
+	}
+	for i, y := range []int8{-1, 0, 2} {
+		if got := legacy.Level.Sections[i].Y; got != y {
+			t.Errorf("Section %d has Y %d, but expected %d", i, got, y)
+		}
+	}
+}
+
+func TestFlattened(t *testing.T) {
+	for _, version := range []int32{2230, Version116} {
+		c := &Flattened{DataVersion: version}
+		var blocks []Block
+		for i := 0; i < 20; i++ {
+			b := Block{Name: fmt.Sprintf("minecraft:block_%d", i)}
+			if i%2 == 0 {
+				b.Properties = map[string]string{"half": "top"}
+			}
+			blocks = append(blocks, b)
+			c.SetBlock(i, i*7, 15-i, b)
+		}
+
+		decoded, ok := roundTrip(t, c).(*Flattened)
+		if !ok {
+			t.Fatalf("%d: Decoded a %T", version, decoded)
+		}
+		for i, b := range blocks {
+			if got := decoded.BlockAt(i, i*7, 15-i); !got.Equal(b) {
+				t.Errorf("%d: Block %d is %v, not %v", version, i, got, b)
+			}
+		}
+		if b := decoded.BlockAt(5, 5, 5); !b.Equal(air) {
+			t.Errorf("%d: Unset block is %v", version, b)
+		}
+
+		// Section 0 has air and blocks 0 to 2, and 15 more blocks make 4 bits
+		// too few.
+		s := decoded.Section(0)
+		if len(s.Palette) != 4 || len(decoded.Section(8).Palette) != 2 {
+			t.Errorf("%d: Palettes are %v and %v", version, s.Palette, decoded.Section(8).Palette)
+		}
+		for x := 0; x < 15; x++ {
+			decoded.SetBlock(x, 15, 0, Block{Name: fmt.Sprintf("minecraft:extra_%d", x)})
+		}
+		decoded.SetBlock(0, 0, 0, Block{Name: "minecraft:stone"})
+		want := 342
+		if version < Version116 {
+			want = 320
+		}
+		if len(s.BlockStates) != want {
+			t.Errorf("%d: %d longs of block states", version, len(s.BlockStates))
+		}
+		if b := decoded.BlockAt(0, 0, 0); b.Name != "minecraft:stone" || decoded.BlockAt(14, 15, 0).Name != "minecraft:extra_14" {
+			t.Errorf("%d: Block is %v after repacking", version, b)
+		}
+		if b := decoded.BlockAt(2, 14, 13); !b.Equal(blocks[2]) {
+			t.Errorf("%d: Block is %v after repacking", version, b)
+		}
+	}
+}
+
+func TestModern(t *testing.T) {
+	c := roundTrip(t, map[string]interface{}{
+		"DataVersion": int32(3120),
+		"xPos":        int32(1),
+		"zPos":        int32(2),
+		"yPos":        int32(-4),
+		"Status":      "minecraft:full",
+		"Heightmaps":  map[string]interface{}{"WORLD_SURFACE": make([]int64, 37)},
+		"sections": []interface{}{
+			map[string]interface{}{
+				"Y":        int8(-5),
+				"SkyLight": make([]byte, 2048),
+			},
+			map[string]interface{}{
+				"Y": int8(-4),
+				"block_states": map[string]interface{}{
+					"palette": []interface{}{map[string]interface{}{"Name": "minecraft:deepslate"}},
+				},
+				"biomes": map[string]interface{}{
+					"palette": []interface{}{"minecraft:plains", "minecraft:desert"},
+					"data":    []int64{0x2},
+				},
+			},
+		},
+	})
+	modern, ok := c.(*Modern)
+	if !ok {
+		t.Fatalf("Decoded a %T", c)
+	}
+	if b := modern.BlockAt(3, -60, 3); b.Name != "minecraft:deepslate" {
+		t.Errorf("Block is %v", b)
+	}
+	if b := modern.BlockAt(3, -70, 3); !b.Equal(air) {
+		t.Errorf("Block in a light section is %v", b)
+	}
+	if biome := modern.BiomeAt(4, -64, 0); biome != "minecraft:desert" {
+		t.Errorf("Biome is %q", biome)
+	}
+	if biome := modern.BiomeAt(0, -64, 0); biome != "minecraft:plains" {
+		t.Errorf("Biome is %q", biome)
+	}
+
+	lever := Block{Name: "minecraft:lever", Properties: map[string]string{"face": "floor", "powered": "true"}}
+	modern.SetBlock(17, -61, 31, lever)
+	modern.SetBlock(0, 100, 0, Block{Name: "minecraft:glass"})
+	if b := modern.BlockAt(1, -61, 15); !b.Equal(lever) || b.String() != "minecraft:lever[face=floor,powered=true]" {
+		t.Errorf("Block is %v after setting it", b)
+	}
+	if b := modern.BlockAt(1, -60, 15); b.Name != "minecraft:deepslate" {
+		t.Errorf("Neighbour is %v after setting a block", b)
+	}
+	if len(modern.Sections) != 3 || modern.Sections[2].Y != 6 {
+		t.Errorf("Added a section to %+v", modern.Sections)
+	}
+
+	root := tree(t, modern)
+	for _, name := range []string{"yPos", "Status", "Heightmaps"} {
+		if root[name] == nil {
+			t.Errorf("Lost %s", name)
+		}
+	}
+	sections := root["sections"].([]interface{})
+	if light := sections[0].(map[string]interface{}); !reflect.DeepEqual(light, map[string]interface{}{
+		"Y": int8(-5), "SkyLight": make([]byte, 2048),
+	}) {
+		t.Errorf("Light section became %v", light)
+	}
+	states := sections[1].(map[string]interface{})["block_states"].(map[string]interface{})
+	if len(states["data"].([]int64)) != 256 {
+		t.Errorf("Block states are %v", states)
+	}
+}
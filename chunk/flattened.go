@@ -0,0 +1,134 @@
+package chunk
+
+import nbt "github.com/Nightgunner5/go.nbt"
+
+// A Flattened chunk is one saved by 1.13 to 1.17, whose sections hold palettes
+// of block states.
+type Flattened struct {
+	DataVersion int32
+	Level       FlattenedLevel
+	Other       map[string]nbt.RawTag `nbt:"-"`
+}
+
+type FlattenedLevel struct {
+	X        int32 `nbt:"xPos"`
+	Z        int32 `nbt:"zPos"`
+	Sections []FlattenedSection
+	Other    map[string]nbt.RawTag `nbt:"-"`
+}
+
+// A FlattenedSection holds the blocks of a 16-block-high slice of a chunk as
+// indexes into Palette, packed into BlockStates with at least 4 bits each.
+// Sections that only hold light have no palette.
+type FlattenedSection struct {
+	Y           int8
+	Palette     []Block
+	BlockStates []int64               `nbt:",array"`
+	Other       map[string]nbt.RawTag `nbt:"-"`
+}
+
+func (c *Flattened) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, c, c.Other)
+}
+
+func (c *Flattened) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, c, &c.Other)
+}
+
+func (level *FlattenedLevel) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, level, level.Other)
+}
+
+func (level *FlattenedLevel) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, level, &level.Other)
+}
+
+func (s *FlattenedSection) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, s, s.Other)
+}
+
+func (s *FlattenedSection) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, s, &s.Other)
+}
+
+func (c *Flattened) Position() (x, z int32) {
+	return c.Level.X, c.Level.Z
+}
+
+// Returns the section at a height, in sections, or nil.
+func (c *Flattened) Section(y int) *FlattenedSection {
+	for i := range c.Level.Sections {
+		if int(c.Level.Sections[i].Y) == y {
+			return &c.Level.Sections[i]
+		}
+	}
+	return nil
+}
+
+func (c *Flattened) states(s *FlattenedSection) packed {
+	return packed{data: s.BlockStates, bits: paletteBits(len(s.Palette), 4), spanning: c.DataVersion < Version116}
+}
+
+// Returns the block at a position in the chunk. Only the low 4 bits of x and
+// z are used, so world coordinates work too. Blocks in missing sections are
+// air.
+func (c *Flattened) BlockAt(x, y, z int) Block {
+	s := c.Section(y >> 4)
+	if s == nil || len(s.Palette) == 0 {
+		return air
+	}
+	return paletteEntry(s.Palette, c.states(s).get(blockIndex(x, y, z)))
+}
+
+// Sets the block at a position in the chunk, adding it to the section's
+// palette and the section to the chunk if need be.
+func (c *Flattened) SetBlock(x, y, z int, b Block) {
+	s := c.Section(y >> 4)
+	if s == nil {
+		i := 0
+		for i < len(c.Level.Sections) && int(c.Level.Sections[i].Y) < y>>4 {
+			i++
+		}
+		c.Level.Sections = append(c.Level.Sections, FlattenedSection{})
+		copy(c.Level.Sections[i+1:], c.Level.Sections[i:])
+		c.Level.Sections[i] = FlattenedSection{Y: int8(y >> 4)}
+		s = &c.Level.Sections[i]
+	}
+	if len(s.Palette) == 0 {
+		s.Palette = []Block{air}
+	}
+
+	p := c.states(s)
+	index := paletteIndex(&s.Palette, b)
+	p.setIndex(sectionVolume, blockIndex(x, y, z), index, paletteBits(len(s.Palette), 4))
+	s.BlockStates = p.data
+}
+
+// Returns a palette's entry, or air if the index is out of range.
+func paletteEntry(palette []Block, i int) Block {
+	if i >= len(palette) {
+		return air
+	}
+	return palette[i]
+}
+
+// Returns the index of a block in a palette, adding it if it isn't there.
+func paletteIndex(palette *[]Block, b Block) int {
+	for i, el := range *palette {
+		if el.Equal(b) {
+			return i
+		}
+	}
+	*palette = append(*palette, b)
+	return len(*palette) - 1
+}
+
+// Sets number i of the n in a paletted container to an index into the palette,
+// repacking the numbers with the given number of bits if they need more or
+// are missing.
+func (p *packed) setIndex(n, i, index, bits int) {
+	if bits > p.bits || len(p.data) < p.longs(n) {
+		*p = p.repack(n, bits)
+	}
+	p.set(i, index)
+}
@@ -0,0 +1,148 @@
+package chunk
+
+import nbt "github.com/Nightgunner5/go.nbt"
+
+// A Legacy chunk is one saved before 1.13, whose blocks have numeric IDs.
+type Legacy struct {
+	Level LegacyLevel
+	Other map[string]nbt.RawTag `nbt:"-"`
+}
+
+type LegacyLevel struct {
+	X        int32 `nbt:"xPos"`
+	Z        int32 `nbt:"zPos"`
+	Sections []LegacySection
+	Other    map[string]nbt.RawTag `nbt:"-"`
+}
+
+// A LegacySection holds the blocks of a 16-block-high slice of a chunk, with
+// 8 bits of each block's ID in Blocks, 4 more in Add, if the section has it,
+// and 4 bits of block data in Data. The arrays of 4-bit numbers put even
+// blocks in the low half of each byte.
+type LegacySection struct {
+	Y          int8
+	Blocks     []byte                `nbt:",array"`
+	Add        []byte                `nbt:",array"`
+	Data       []byte                `nbt:",array"`
+	BlockLight []byte                `nbt:",array"`
+	SkyLight   []byte                `nbt:",array"`
+	Other      map[string]nbt.RawTag `nbt:"-"`
+}
+
+// A LegacyBlock is a block ID, like 1 for stone, and its block data, like 2
+// for polished granite.
+type LegacyBlock struct {
+	ID   uint16
+	Data uint8
+}
+
+func (c *Legacy) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, c, c.Other)
+}
+
+func (c *Legacy) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, c, &c.Other)
+}
+
+func (level *LegacyLevel) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, level, level.Other)
+}
+
+func (level *LegacyLevel) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, level, &level.Other)
+}
+
+func (s *LegacySection) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, s, s.Other)
+}
+
+func (s *LegacySection) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, s, &s.Other)
+}
+
+func (c *Legacy) Position() (x, z int32) {
+	return c.Level.X, c.Level.Z
+}
+
+// Returns the section at a height, in sections, or nil.
+func (c *Legacy) Section(y int) *LegacySection {
+	for i := range c.Level.Sections {
+		if int(c.Level.Sections[i].Y) == y {
+			return &c.Level.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Returns the block at a position in the chunk. Only the low 4 bits of x and
+// z are used, so world coordinates work too. Blocks in missing sections are
+// air.
+func (c *Legacy) BlockAt(x, y, z int) LegacyBlock {
+	s := c.Section(y >> 4)
+	if s == nil {
+		return LegacyBlock{}
+	}
+	i := blockIndex(x, y, z)
+	b := LegacyBlock{Data: nibble(s.Data, i)}
+	if i < len(s.Blocks) {
+		b.ID = uint16(s.Blocks[i])
+	}
+	b.ID |= uint16(nibble(s.Add, i)) << 8
+	return b
+}
+
+// Sets the block at a position in the chunk, adding its section if it is
+// missing. New sections are full of sky light.
+func (c *Legacy) SetBlock(x, y, z int, b LegacyBlock) {
+	s := c.Section(y >> 4)
+	if s == nil {
+		i := 0
+		for i < len(c.Level.Sections) && int(c.Level.Sections[i].Y) < y>>4 {
+			i++
+		}
+		c.Level.Sections = append(c.Level.Sections, LegacySection{})
+		copy(c.Level.Sections[i+1:], c.Level.Sections[i:])
+		c.Level.Sections[i] = LegacySection{
+			Y:          int8(y >> 4),
+			Blocks:     make([]byte, sectionVolume),
+			Data:       make([]byte, sectionVolume/2),
+			BlockLight: make([]byte, sectionVolume/2),
+			SkyLight:   make([]byte, sectionVolume/2),
+		}
+		s = &c.Level.Sections[i]
+		for i := range s.SkyLight {
+			s.SkyLight[i] = 0xff
+		}
+	}
+
+	i := blockIndex(x, y, z)
+	if len(s.Blocks) < sectionVolume {
+		s.Blocks = append(s.Blocks, make([]byte, sectionVolume-len(s.Blocks))...)
+	}
+	s.Blocks[i] = byte(b.ID)
+	if b.ID > 0xff && s.Add == nil {
+		s.Add = make([]byte, sectionVolume/2)
+	}
+	if s.Add != nil {
+		s.Add = setNibble(s.Add, i, uint8(b.ID>>8))
+	}
+	s.Data = setNibble(s.Data, i, b.Data)
+}
+
+// Returns the 4-bit number i in an array, or 0 if it is too short.
+func nibble(a []byte, i int) uint8 {
+	if i/2 >= len(a) {
+		return 0
+	}
+	return a[i/2] >> (uint(i&1) * 4) & 0xf
+}
+
+// Sets the 4-bit number i in an array, growing it if it is too short.
+func setNibble(a []byte, i int, v uint8) []byte {
+	if len(a) < sectionVolume/2 {
+		a = append(a, make([]byte, sectionVolume/2-len(a))...)
+	}
+	shift := uint(i&1) * 4
+	a[i/2] = a[i/2]&^(0xf<<shift) | (v&0xf)<<shift
+	return a
+}
@@ -0,0 +1,160 @@
+package chunk
+
+import nbt "github.com/Nightgunner5/go.nbt"
+
+// A Modern chunk is one saved by 1.18 or later, whose sections hold palettes of
+// block states and biomes.
+type Modern struct {
+	DataVersion int32
+	X           int32                 `nbt:"xPos"`
+	Z           int32                 `nbt:"zPos"`
+	Sections    []Section             `nbt:"sections"`
+	Other       map[string]nbt.RawTag `nbt:"-"`
+}
+
+// A Section holds the blocks and biomes of a 16-block-high slice of a chunk.
+// Sections that only hold light have neither.
+type Section struct {
+	Y           int8
+	BlockStates *BlockStates          `nbt:"block_states"`
+	Biomes      *Biomes               `nbt:"biomes"`
+	Other       map[string]nbt.RawTag `nbt:"-"`
+}
+
+// BlockStates holds the blocks of a section as indexes into Palette, packed
+// into Data with at least 4 bits each. If the palette has one entry, there is
+// no data.
+type BlockStates struct {
+	Palette []Block               `nbt:"palette"`
+	Data    []int64               `nbt:"data,array"`
+	Other   map[string]nbt.RawTag `nbt:"-"`
+}
+
+// Biomes holds the biomes of the 4 by 4 by 4 cells of a section as indexes
+// into Palette, packed into Data. If the palette has one entry, there is no
+// data.
+type Biomes struct {
+	Palette []string              `nbt:"palette"`
+	Data    []int64               `nbt:"data,array"`
+	Other   map[string]nbt.RawTag `nbt:"-"`
+}
+
+func (c *Modern) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, c, c.Other)
+}
+
+func (c *Modern) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, c, &c.Other)
+}
+
+func (s *Section) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, s, s.Other)
+}
+
+func (s *Section) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, s, &s.Other)
+}
+
+func (states *BlockStates) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, states, states.Other)
+}
+
+func (states *BlockStates) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, states, &states.Other)
+}
+
+func (biomes *Biomes) MarshalNBT(w *nbt.Writer) error {
+	return writeCompound(w, biomes, biomes.Other)
+}
+
+func (biomes *Biomes) UnmarshalNBT(r *nbt.Reader) error {
+	return readCompound(r, biomes, &biomes.Other)
+}
+
+func (c *Modern) Position() (x, z int32) {
+	return c.X, c.Z
+}
+
+// Returns the section at a height, in sections, or nil.
+func (c *Modern) Section(y int) *Section {
+	for i := range c.Sections {
+		if int(c.Sections[i].Y) == y {
+			return &c.Sections[i]
+		}
+	}
+	return nil
+}
+
+func (states *BlockStates) packed() packed {
+	p := packed{data: states.Data}
+	if len(states.Palette) > 1 {
+		p.bits = paletteBits(len(states.Palette), 4)
+	}
+	return p
+}
+
+func (biomes *Biomes) packed() packed {
+	p := packed{data: biomes.Data}
+	if len(biomes.Palette) > 1 {
+		p.bits = paletteBits(len(biomes.Palette), 1)
+	}
+	return p
+}
+
+// Returns the block at a position in the chunk. Only the low 4 bits of x and
+// z are used, so world coordinates work too. Blocks in missing sections are
+// air.
+func (c *Modern) BlockAt(x, y, z int) Block {
+	s := c.Section(y >> 4)
+	if s == nil || s.BlockStates == nil || len(s.BlockStates.Palette) == 0 {
+		return air
+	}
+	return paletteEntry(s.BlockStates.Palette, s.BlockStates.packed().get(blockIndex(x, y, z)))
+}
+
+// Sets the block at a position in the chunk, adding it to the section's
+// palette and the section to the chunk if need be. New sections have no
+// biomes.
+func (c *Modern) SetBlock(x, y, z int, b Block) {
+	s := c.Section(y >> 4)
+	if s == nil {
+		i := 0
+		for i < len(c.Sections) && int(c.Sections[i].Y) < y>>4 {
+			i++
+		}
+		c.Sections = append(c.Sections, Section{})
+		copy(c.Sections[i+1:], c.Sections[i:])
+		c.Sections[i] = Section{Y: int8(y >> 4)}
+		s = &c.Sections[i]
+	}
+	if s.BlockStates == nil {
+		s.BlockStates = new(BlockStates)
+	}
+	states := s.BlockStates
+	if len(states.Palette) == 0 {
+		states.Palette = []Block{air}
+	}
+
+	p := states.packed()
+	index := paletteIndex(&states.Palette, b)
+	if len(states.Palette) == 1 {
+		return
+	}
+	p.setIndex(sectionVolume, blockIndex(x, y, z), index, paletteBits(len(states.Palette), 4))
+	states.Data = p.data
+}
+
+// Returns the biome at a position in the chunk, like minecraft:plains, or ""
+// if the section has no biomes. Biomes are stored for cells of 4 by 4 by 4
+// blocks.
+func (c *Modern) BiomeAt(x, y, z int) string {
+	s := c.Section(y >> 4)
+	if s == nil || s.Biomes == nil || len(s.Biomes.Palette) == 0 {
+		return ""
+	}
+	i := s.Biomes.packed().get((y&15)>>2<<4 | (z&15)>>2<<2 | (x&15)>>2)
+	if i >= len(s.Biomes.Palette) {
+		return ""
+	}
+	return s.Biomes.Palette[i]
+}
@@ -0,0 +1,95 @@
+package chunk
+
+import "math/bits"
+
+// The number of blocks in a section.
+const sectionVolume = 16 * 16 * 16
+
+// Returns the index of a block in a section, in the YZX order blocks are
+// stored in.
+func blockIndex(x, y, z int) int {
+	return (y&15)<<8 | (z&15)<<4 | x&15
+}
+
+// A packed array of unsigned numbers of a fixed number of bits, as block
+// states and biomes are stored.
+type packed struct {
+	data []int64
+	bits int
+
+	// Whether numbers may span two longs, as they did before 1.16. Since
+	// then, each long holds as many whole numbers as fit and the rest of its
+	// bits are unused.
+	spanning bool
+}
+
+// Returns the number of bits needed for indexes into a palette of n entries,
+// but at least min.
+func paletteBits(n, min int) int {
+	b := bits.Len(uint(n - 1))
+	if b < min {
+		return min
+	}
+	return b
+}
+
+// Returns the number of longs needed to hold n numbers.
+func (p packed) longs(n int) int {
+	if p.bits == 0 {
+		return 0
+	}
+	if p.spanning {
+		return (n*p.bits + 63) / 64
+	}
+	perLong := 64 / p.bits
+	return (n + perLong - 1) / perLong
+}
+
+// Returns the long number i starts in and its offset within it.
+func (p packed) locate(i int) (int, uint) {
+	if p.spanning {
+		return i * p.bits / 64, uint(i * p.bits % 64)
+	}
+	perLong := 64 / p.bits
+	return i / perLong, uint(i % perLong * p.bits)
+}
+
+// Returns number i, or 0 if the data is too short to hold it, as it is when a
+// palette has only one entry.
+func (p packed) get(i int) int {
+	if p.bits == 0 {
+		return 0
+	}
+	j, shift := p.locate(i)
+	if j >= len(p.data) {
+		return 0
+	}
+	mask := uint64(1)<<uint(p.bits) - 1
+	v := uint64(p.data[j]) >> shift
+	if shift+uint(p.bits) > 64 && j+1 < len(p.data) {
+		v |= uint64(p.data[j+1]) << (64 - shift)
+	}
+	return int(v & mask)
+}
+
+// Sets number i. The data must be long enough to hold it.
+func (p packed) set(i, v int) {
+	j, shift := p.locate(i)
+	mask := uint64(1)<<uint(p.bits) - 1
+	p.data[j] = int64(uint64(p.data[j])&^(mask<<shift) | uint64(v)&mask<<shift)
+	if shift+uint(p.bits) > 64 {
+		rest := 64 - shift
+		p.data[j+1] = int64(uint64(p.data[j+1])&^(mask>>rest) | uint64(v)&mask>>rest)
+	}
+}
+
+// Returns a copy of the first n numbers packed with a different number of
+// bits.
+func (p packed) repack(n, bits int) packed {
+	q := packed{bits: bits, spanning: p.spanning}
+	q.data = make([]int64, q.longs(n))
+	for i := 0; i < n; i++ {
+		q.set(i, p.get(i))
+	}
+	return q
+}
@@ -0,0 +1,242 @@
+// Command mcregion manages the chunks in Minecraft region files (.mca).
+//
+// Usage:
+//
+//	mcregion list FILE
+//	mcregion extract FILE X Z OUTPUT
+//	mcregion replace FILE X Z INPUT
+//	mcregion delete FILE X Z
+//	mcregion prune FILE MINX MINZ MAXX MAXZ
+//	mcregion compact FILE
+//
+// list prints each chunk's coordinates, where it is in the file, its size and
+// when it was last saved. extract writes a chunk out as a standalone gzipped
+// NBT file, and replace reads one back in, with any compression. Chunk
+// coordinates may be world or region-relative.
+//
+// prune deletes the chunks outside a bounding box, given in world chunk
+// coordinates and including its edges. The file must have its usual name,
+// like r.-1.2.mca, for the chunks' world coordinates to be known.
+//
+// Deleting and replacing chunks can leave unused space in the file; compact
+// moves the chunks to close the gaps and shortens the file.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+	"github.com/Nightgunner5/go.nbt/region"
+)
+
+var commands = map[string]struct {
+	args int
+	run  func(file string, args []string) error
+	help string
+}{
+	"list":    {0, list, "FILE"},
+	"extract": {3, extract, "FILE X Z OUTPUT"},
+	"replace": {3, replace, "FILE X Z INPUT"},
+	"delete":  {2, deleteChunk, "FILE X Z"},
+	"prune":   {4, prune, "FILE MINX MINZ MAXX MAXZ"},
+	"compact": {0, compact, "FILE"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	for _, name := range []string{"list", "extract", "replace", "delete", "prune", "compact"} {
+		fmt.Fprintf(os.Stderr, "  mcregion %s %s\n", name, commands[name].help)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok || len(os.Args)-3 != cmd.args {
+		usage()
+	}
+
+	if err := cmd.run(os.Args[2], os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "mcregion: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Parses chunk coordinates.
+func coords(args []string) ([]int, error) {
+	n := make([]int, len(args))
+	for i, s := range args {
+		var err error
+		if n[i], err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("%q is not a coordinate", s)
+		}
+	}
+	return n, nil
+}
+
+func list(file string, _ []string) error {
+	r, err := region.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		return err
+	}
+	rx, rz, named := region.ParseName(file)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "x\tz\tsector\tsectors\tbytes\tcompression\tmodified\t\n")
+	for _, c := range chunks {
+		x, z := c.X, c.Z
+		if named {
+			x, z = rx*region.Width+x, rz*region.Width+z
+		}
+		compression := c.Compression.String()
+		if c.External {
+			compression += " (external)"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%s\t%s\t\n", x, z, c.Sector, c.Sectors, c.Length,
+			compression, c.Modified.Format("2006-01-02 15:04:05"))
+	}
+	return w.Flush()
+}
+
+func extract(file string, args []string) error {
+	c, err := coords(args[:2])
+	if err != nil {
+		return err
+	}
+	r, err := region.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// A RawTag passes the chunk through without changing the order of its
+	// tags.
+	var raw nbt.RawTag
+	data, compression, err := r.ReadChunk(c[0], c[1])
+	if err != nil {
+		return err
+	}
+	name, err := nbt.UnmarshalNamed(compression, bytes.NewReader(data), &raw)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := nbt.MarshalNamed(nbt.GZip, &buf, name, raw); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args[2], buf.Bytes(), 0644)
+}
+
+func replace(file string, args []string) error {
+	c, err := coords(args[:2])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+	var raw nbt.RawTag
+	name, err := nbt.UnmarshalNamed(nbt.DetectCompression(data), bytes.NewReader(data), &raw)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := nbt.MarshalNamed(nbt.ZLib, &buf, name, raw); err != nil {
+		return err
+	}
+
+	r, err := region.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteChunk(c[0], c[1], buf.Bytes(), nbt.ZLib); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
+
+func deleteChunk(file string, args []string) error {
+	c, err := coords(args[:2])
+	if err != nil {
+		return err
+	}
+	r, err := region.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := r.DeleteChunk(c[0], c[1]); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
+
+func prune(file string, args []string) error {
+	c, err := coords(args[:4])
+	if err != nil {
+		return err
+	}
+	rx, rz, ok := region.ParseName(file)
+	if !ok {
+		return fmt.Errorf("%s is not named like r.X.Z.mca, so its chunks' coordinates are unknown", file)
+	}
+	minX, minZ, maxX, maxZ := c[0], c[1], c[2], c[3]
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	if minZ > maxZ {
+		minZ, maxZ = maxZ, minZ
+	}
+
+	r, err := region.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for z := 0; z < region.Width; z++ {
+		for x := 0; x < region.Width; x++ {
+			wx, wz := rx*region.Width+x, rz*region.Width+z
+			if !r.Has(x, z) || (wx >= minX && wx <= maxX && wz >= minZ && wz <= maxZ) {
+				continue
+			}
+			if err := r.DeleteChunk(x, z); err != nil {
+				r.Close()
+				return err
+			}
+			deleted++
+		}
+	}
+	fmt.Printf("deleted %d chunks\n", deleted)
+	return r.Close()
+}
+
+func compact(file string, _ []string) error {
+	r, err := region.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := r.Compact(); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
@@ -0,0 +1,163 @@
+// Command nbtconv converts NBT documents between formats.
+//
+// Usage:
+//
+//	nbtconv -from FORMAT -to FORMAT [flags] [input [output]]
+//
+// The input is read from standard input and the output written to standard
+// output if they are not given, or if they are "-". The formats are:
+//
+//	java             binary, big endian, as Java Edition writes it (the default)
+//	bedrock          binary, little endian, as Bedrock Edition writes to disk
+//	bedrock-network  binary, with varints, as Bedrock Edition sends it
+//	snbt             SNBT, as used in Minecraft commands
+//	json             typed JSON, which keeps every tag type
+//
+// The compression of binary input is detected unless -in-compression is set.
+// Binary output is compressed with -out-compression, which defaults to the
+// compression of the input.
+//
+// Conversions between binary formats and typed JSON keep the root name and the
+// order of compound entries. SNBT has no root name, and nbtconv writes its
+// compound entries in sorted order.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+var (
+	from           = flag.String("from", "java", "`format` of the input: java, bedrock, bedrock-network, snbt or json")
+	to             = flag.String("to", "java", "`format` of the output: java, bedrock, bedrock-network, snbt or json")
+	inCompression  = flag.String("in-compression", "auto", "`compression` of binary input: auto, none, gzip or zlib")
+	outCompression = flag.String("out-compression", "", "`compression` of binary output: none, gzip or zlib (default the same as the input)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nbtconv -from FORMAT -to FORMAT [flags] [input [output]]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := convert(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "nbtconv: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Returns the binary dialect a format names, or false for the text formats.
+func dialectOf(format string) (nbt.Dialect, bool, error) {
+	switch format {
+	case "snbt", "json":
+		return 0, false, nil
+	}
+	d, err := nbt.ParseDialect(format)
+	if err != nil {
+		return 0, false, fmt.Errorf("unknown format %q", format)
+	}
+	return d, true, nil
+}
+
+func convert(input, output string) error {
+	inDialect, inBinary, err := dialectOf(*from)
+	if err != nil {
+		return err
+	}
+	outDialect, outBinary, err := dialectOf(*to)
+	if err != nil {
+		return err
+	}
+
+	data, err := readInput(input)
+	if err != nil {
+		return err
+	}
+
+	// Everything is converted through a binary document, since that is what
+	// every format can be read from and written to.
+	c := nbt.Uncompressed
+	switch {
+	case inBinary:
+		c = nbt.DetectCompression(data)
+		if *inCompression != "auto" {
+			if c, err = nbt.ParseCompression(*inCompression); err != nil {
+				return err
+			}
+		}
+	case *from == "snbt":
+		v, err := nbt.ParseSNBT(string(data))
+		if err != nil {
+			return err
+		}
+		if data, err = nbt.AppendMarshal(nil, v); err != nil {
+			return err
+		}
+	default:
+		var buf bytes.Buffer
+		if err := nbt.FromJSON(nbt.Uncompressed, &buf, bytes.NewReader(data), nbt.TypedJSON); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	dec := nbt.Decoder{Dialect: inDialect}
+
+	var out bytes.Buffer
+	switch {
+	case outBinary:
+		oc := c
+		if *outCompression != "" {
+			if oc, err = nbt.ParseCompression(*outCompression); err != nil {
+				return err
+			}
+		}
+		enc := nbt.Encoder{Dialect: outDialect}
+		err = enc.Transcode(oc, &out, &dec, c, bytes.NewReader(data))
+	case *to == "snbt":
+		var tree interface{}
+		if err := dec.Unmarshal(c, bytes.NewReader(data), &tree); err != nil {
+			return err
+		}
+		s, err := nbt.FormatSNBT(tree)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(&out, s)
+	default:
+		err = dec.ToJSON(c, bytes.NewReader(data), &out, nbt.TypedJSON)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(output, out.Bytes())
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "" || file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+func writeOutput(file string, data []byte) error {
+	if file == "" || file == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,329 @@
+// Command nbtdump prints an NBT file in a readable form.
+//
+// Usage:
+//
+//	nbtdump [flags] [file]
+//
+// The file is read from standard input if it is not given. Its compression is
+// detected unless -compression is set. The output formats are:
+//
+//	tree        one tag per line, indented (the default)
+//	snbt        SNBT, as used in Minecraft commands
+//	json        plain JSON, for display
+//	typed-json  JSON that keeps every tag type and can be converted back
+//
+// -depth and -array shorten the output of every format but typed-json: lists
+// and compounds deeper than -depth are left out, and only the first -array
+// elements of arrays are shown. With -path, only the tags the NBT path
+// selects are printed, each with its own path.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+var (
+	format      = flag.String("format", "tree", "output `format`: tree, snbt, json or typed-json")
+	dialect     = flag.String("dialect", "java", "binary `dialect` of the input: java (big endian), bedrock (little endian) or bedrock-network")
+	compression = flag.String("compression", "auto", "`compression` of the input: auto, none, gzip or zlib")
+	maxDepth    = flag.Int("depth", 0, "leave out lists and compounds nested deeper than `n` (0 for no limit)")
+	maxArray    = flag.Int("array", 0, "show only the first `n` elements of arrays (0 for no limit)")
+	pathFlag    = flag.String("path", "", "print only the tags selected by this NBT `path`")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nbtdump [flags] [file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := dump(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "nbtdump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func dump(file string) error {
+	data, err := readInput(file)
+	if err != nil {
+		return err
+	}
+
+	var dec nbt.Decoder
+	if dec.Dialect, err = nbt.ParseDialect(*dialect); err != nil {
+		return err
+	}
+	c := nbt.DetectCompression(data)
+	if *compression != "auto" {
+		if c, err = nbt.ParseCompression(*compression); err != nil {
+			return err
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if *format == "typed-json" {
+		if *pathFlag != "" || *maxDepth != 0 || *maxArray != 0 {
+			return fmt.Errorf("-path, -depth and -array can't be used with typed-json")
+		}
+		return dec.ToJSON(c, bytes.NewReader(data), out, nbt.TypedJSON)
+	}
+
+	var matches []nbt.Match
+	if *pathFlag != "" {
+		path, err := nbt.ParsePath(*pathFlag)
+		if err != nil {
+			return err
+		}
+		if matches, err = dec.Query(c, bytes.NewReader(data), path); err != nil {
+			return err
+		}
+	} else {
+		var tree interface{}
+		if err := dec.Unmarshal(c, bytes.NewReader(data), &tree); err != nil {
+			return err
+		}
+		matches = []nbt.Match{{Value: tree}}
+	}
+
+	for _, m := range matches {
+		switch *format {
+		case "tree":
+			name := m.Path
+			if name == "" {
+				name = "(root)"
+			}
+			printTree(out, name, m.Value, 0)
+
+		case "snbt":
+			s, err := nbt.FormatSNBT(prune(m.Value, 0))
+			if err != nil {
+				return err
+			}
+			if *pathFlag != "" {
+				fmt.Fprintf(out, "%s: ", m.Path)
+			}
+			fmt.Fprintln(out, s)
+
+		case "json":
+			if err := printJSON(out, m); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown format %q", *format)
+		}
+	}
+	return nil
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "" || file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// Returns a copy of a tree cut down to -depth and -array.
+func prune(v interface{}, depth int) interface{} {
+	deep := *maxDepth > 0 && depth >= *maxDepth
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		if !deep {
+			for name, el := range v {
+				c[name] = prune(el, depth+1)
+			}
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, 0, len(v))
+		if !deep {
+			for _, el := range v {
+				c = append(c, prune(el, depth+1))
+			}
+		}
+		return c
+	case []byte:
+		return v[:arrayLen(len(v))]
+	case []int32:
+		return v[:arrayLen(len(v))]
+	case []int64:
+		return v[:arrayLen(len(v))]
+	}
+	return v
+}
+
+func arrayLen(n int) int {
+	if *maxArray > 0 && n > *maxArray {
+		return *maxArray
+	}
+	return n
+}
+
+func printJSON(w io.Writer, m nbt.Match) error {
+	data, err := nbt.AppendMarshal(nil, inOrder(prune(m.Value, 0)))
+	if err != nil {
+		return err
+	}
+	var value bytes.Buffer
+	if err := nbt.ToJSON(nbt.Uncompressed, bytes.NewReader(data), &value, nbt.PlainJSON); err != nil {
+		return err
+	}
+	if *pathFlag == "" {
+		_, err = value.WriteTo(w)
+		return err
+	}
+	path, _ := json.Marshal(m.Path)
+	_, err = fmt.Fprintf(w, "{\"path\":%s,\"value\":%s}\n", path, bytes.TrimSpace(value.Bytes()))
+	return err
+}
+
+// A sorted encodes a compound with its entries in order of name, as the other
+// formats show them, rather than in the random order of a map.
+type sorted map[string]interface{}
+
+func (s sorted) MarshalNBT(w *nbt.Writer) error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Value(name, inOrder(s[name]), "")
+	}
+	return nil
+}
+
+// Returns v with the compounds in it encoded in order of name.
+func inOrder(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return sorted(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, el := range v {
+			list[i] = inOrder(el)
+		}
+		return list
+	}
+	return v
+}
+
+// Returns the name of the tag of a value in a tree Unmarshal produces,
+// without its number, e.g. TAG_Short.
+func tagName(v interface{}) string {
+	var tag nbt.Tag
+	switch v.(type) {
+	case int8:
+		tag = nbt.TagByte
+	case int16:
+		tag = nbt.TagShort
+	case int32:
+		tag = nbt.TagInt
+	case int64:
+		tag = nbt.TagLong
+	case float32:
+		tag = nbt.TagFloat
+	case float64:
+		tag = nbt.TagDouble
+	case []byte:
+		tag = nbt.TagByteArray
+	case string:
+		tag = nbt.TagString
+	case []interface{}:
+		tag = nbt.TagList
+	case map[string]interface{}:
+		tag = nbt.TagCompound
+	case []int32:
+		tag = nbt.TagIntArray
+	case []int64:
+		tag = nbt.TagLongArray
+	default:
+		return "?"
+	}
+	name := tag.String()
+	return name[:strings.IndexByte(name, ' ')]
+}
+
+func printTree(w io.Writer, name string, v interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+	deep := *maxDepth > 0 && depth >= *maxDepth
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		fmt.Fprintf(w, "%s%s: TAG_Compound (%d entries)", indent, name, len(v))
+		if deep {
+			fmt.Fprintln(w, " ...")
+			return
+		}
+		fmt.Fprintln(w)
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			printTree(w, name, v[name], depth+1)
+		}
+
+	case []interface{}:
+		elem := "TAG_End"
+		if len(v) > 0 {
+			elem = tagName(v[0])
+		}
+		fmt.Fprintf(w, "%s%s: TAG_List of %s (%d entries)", indent, name, elem, len(v))
+		if deep {
+			fmt.Fprintln(w, " ...")
+			return
+		}
+		fmt.Fprintln(w)
+		for i, el := range v {
+			printTree(w, fmt.Sprintf("[%d]", i), el, depth+1)
+		}
+
+	case []byte, []int32, []int64:
+		s, _ := nbt.FormatSNBT(prune(v, depth))
+		fmt.Fprintf(w, "%s%s: %s %s", indent, name, tagName(v), s)
+		if shown, total := arrayLen(lenOf(v)), lenOf(v); shown < total {
+			fmt.Fprintf(w, " (%d more)", total-shown)
+		}
+		fmt.Fprintln(w)
+
+	default:
+		s, err := nbt.FormatSNBT(v)
+		if err != nil {
+			s = fmt.Sprint(v) // NaN and the infinities.
+		}
+		fmt.Fprintf(w, "%s%s: %s %s\n", indent, name, tagName(v), s)
+	}
+}
+
+func lenOf(v interface{}) int {
+	switch v := v.(type) {
+	case []byte:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []int64:
+		return len(v)
+	}
+	return 0
+}
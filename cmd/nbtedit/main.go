@@ -0,0 +1,472 @@
+// Command nbtedit makes scripted changes to an NBT file.
+//
+// Usage:
+//
+//	nbtedit [flags] file operation...
+//
+// Operations are applied in order, each addressed by an NBT path, with values
+// written in SNBT:
+//
+//	get PATH           print the tags PATH selects, one per line
+//	set PATH VALUE     set the tags PATH selects, creating them if needed
+//	delete PATH        remove the tags PATH selects
+//	append PATH VALUE  add VALUE to the end of the lists PATH selects
+//
+// For example, to move the world spawn and turn off the daylight cycle:
+//
+//	nbtedit level.dat set Data.SpawnX 100 set Data.SpawnZ -20 \
+//		set Data.GameRules.doDaylightCycle '"false"'
+//
+// A set that replaces a number keeps the number's tag, so set Data.Time 0
+// stores 0 as a TAG_Long if Data.Time is one, and fails if the value doesn't
+// fit. Likewise, a number appended to a list of numbers takes their tag.
+//
+// If any operation changes the file, it is written back with its original
+// compression and root name. Compound entries keep their order, new ones
+// follow them in order of name, and empty lists keep their element tags. The
+// new file is written next to the old one and then renamed over it, so the
+// file is never left half written. Nothing is written if any operation fails.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+var (
+	dialect = flag.String("dialect", "java", "binary `dialect` of the file: java, bedrock or bedrock-network")
+	output  = flag.String("o", "", "write the result to `file` instead of replacing the input")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nbtedit [flags] file operation...\n\n")
+		fmt.Fprintf(os.Stderr, "operations:\n")
+		fmt.Fprintf(os.Stderr, "  get PATH\n  set PATH VALUE\n  delete PATH\n  append PATH VALUE\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := edit(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "nbtedit: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// One operation from the command line.
+type step struct {
+	verb  string
+	path  *nbt.Path
+	value interface{}
+}
+
+// Parses all the operations up front, so that a typo in the last one doesn't
+// leave the others half done.
+func parseSteps(args []string) ([]step, error) {
+	var steps []step
+	for len(args) > 0 {
+		s := step{verb: args[0]}
+		n := 2
+		switch s.verb {
+		case "get", "delete":
+		case "set", "append":
+			n = 3
+		default:
+			return nil, fmt.Errorf("unknown operation %q", s.verb)
+		}
+		if len(args) < n {
+			return nil, fmt.Errorf("%s: expected %d arguments", s.verb, n-1)
+		}
+
+		var err error
+		if s.path, err = nbt.ParsePath(args[1]); err != nil {
+			return nil, err
+		}
+		if n == 3 {
+			if s.value, err = nbt.ParseSNBT(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, s)
+		args = args[n:]
+	}
+	return steps, nil
+}
+
+func edit(file string, args []string) error {
+	steps, err := parseSteps(args)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	d, err := nbt.ParseDialect(*dialect)
+	if err != nil {
+		return err
+	}
+	compression := nbt.DetectCompression(data)
+	dec := nbt.Decoder{Dialect: d}
+	doc := &ordered{layouts: make(map[uintptr]*layout)}
+	name, err := dec.UnmarshalNamed(compression, bytes.NewReader(data), doc)
+	if err != nil {
+		return err
+	}
+	root := doc.m
+
+	changed := false
+	for _, s := range steps {
+		var op nbt.Operation
+		switch s.verb {
+		case "get":
+			matches := s.path.Find(root)
+			if len(matches) == 0 {
+				return fmt.Errorf("get %s: nothing matches", s.path)
+			}
+			for _, m := range matches {
+				value, err := nbt.FormatSNBT(m.Value)
+				if err != nil {
+					return err
+				}
+				fmt.Println(value)
+			}
+			continue
+		case "set":
+			var olds []interface{}
+			for _, m := range s.path.Find(root) {
+				olds = append(olds, m.Value)
+			}
+			value, err := coerceTo(olds, s.value)
+			if err != nil {
+				return fmt.Errorf("set %s: %v", s.path, err)
+			}
+			op = nbt.Operation{Op: nbt.OpSet, Path: s.path, Value: value}
+		case "delete":
+			op = nbt.Operation{Op: nbt.OpRemove, Path: s.path}
+		case "append":
+			var olds []interface{}
+			for _, m := range s.path.Find(root) {
+				if list, ok := m.Value.([]interface{}); ok && len(list) > 0 {
+					olds = append(olds, list[0])
+				}
+			}
+			value, err := coerceTo(olds, s.value)
+			if err != nil {
+				return fmt.Errorf("append %s: %v", s.path, err)
+			}
+			op = nbt.Operation{Op: nbt.OpAppend, Path: s.path, Value: value}
+		}
+		if err := nbt.Apply(root, op); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed && *output == "" {
+		return nil
+	}
+	enc := nbt.Encoder{Dialect: d}
+	var buf bytes.Buffer
+	if err := enc.MarshalNamed(compression, &buf, name, doc); err != nil {
+		return err
+	}
+	target := file
+	if *output != "" {
+		target = *output
+	}
+	return writeFile(target, buf.Bytes())
+}
+
+// Returns v converted to the type of olds, the numbers it replaces or joins in
+// a list, if it is a number and they are all numbers of the same type. It is
+// an error for v not to fit exactly.
+func coerceTo(olds []interface{}, v interface{}) (interface{}, error) {
+	from := reflect.ValueOf(v)
+	if len(olds) == 0 || !isNumber(from.Kind()) {
+		return v, nil
+	}
+	t := reflect.TypeOf(olds[0])
+	for _, old := range olds {
+		if reflect.TypeOf(old) != t || !isNumber(t.Kind()) {
+			return v, nil
+		}
+	}
+
+	to := reflect.New(t).Elem()
+	exact := true
+	switch {
+	case isFloat(from.Kind()) && isFloat(t.Kind()):
+		to.SetFloat(from.Float())
+		exact = to.Float() == from.Float() || math.IsNaN(from.Float())
+	case isFloat(from.Kind()):
+		f := from.Float()
+		exact = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !to.OverflowInt(int64(f))
+		if exact {
+			to.SetInt(int64(f))
+		}
+	case isFloat(t.Kind()):
+		to.SetFloat(float64(from.Int()))
+		exact = int64(to.Float()) == from.Int()
+	default:
+		exact = !to.OverflowInt(from.Int())
+		to.SetInt(from.Int())
+	}
+	if !exact {
+		return nil, fmt.Errorf("%v doesn't fit in the %s it replaces", v, t)
+	}
+	return to.Interface(), nil
+}
+
+// Reports whether values of kind k are numbers in a tree Unmarshal produces.
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// An ordered decodes and encodes a compound like a map[string]interface{},
+// but remembers what such a tree loses: the order of each compound's entries
+// and the element tags of empty lists. They are kept in layouts, keyed by the
+// address of the map or list they describe. The edits change maps in place, so
+// the layouts still apply afterwards; compounds and lists they don't cover are
+// written with entries in order of name.
+type ordered struct {
+	m       map[string]interface{}
+	layouts map[uintptr]*layout
+}
+
+// What a tree doesn't record about one of its compounds or lists.
+type layout struct {
+	// The map or list itself, so that while its layout is kept, its address
+	// isn't reused for one an edit makes.
+	holds interface{}
+	names []string           // Entry order, for a compound.
+	empty map[string]nbt.Tag // Element tags of the empty lists in it, by name or index.
+}
+
+// Returns the element tag recorded for the empty list under key, or TagEnd.
+func (l *layout) emptyElem(key string) nbt.Tag {
+	if l == nil {
+		return nbt.TagEnd
+	}
+	return l.empty[key]
+}
+
+func addressOf(v interface{}) uintptr {
+	return reflect.ValueOf(v).Pointer()
+}
+
+func (o *ordered) UnmarshalNBT(r *nbt.Reader) error {
+	o.m = make(map[string]interface{})
+	l := &layout{holds: o.m}
+	for {
+		name, tag := r.Entry()
+		if tag == nbt.TagEnd {
+			break
+		}
+		l.names = append(l.names, name)
+		o.m[name] = o.read(r, tag, l, name)
+	}
+	o.layouts[addressOf(o.m)] = l
+	return nil
+}
+
+// Reads a payload the way Unmarshal decodes one into an interface{}. If it is
+// an empty list, its element tag goes in parent, the layout of the compound or
+// list holding it, under key.
+func (o *ordered) read(r *nbt.Reader, tag nbt.Tag, parent *layout, key string) interface{} {
+	switch tag {
+	case nbt.TagCompound:
+		c := &ordered{layouts: o.layouts}
+		r.Compound(tag, c)
+		return c.m
+	case nbt.TagList:
+		elem, n := r.List(tag)
+		list := make([]interface{}, n)
+		if n == 0 {
+			if parent.empty == nil {
+				parent.empty = make(map[string]nbt.Tag)
+			}
+			parent.empty[key] = elem
+			return list
+		}
+		l := &layout{holds: list}
+		for i := range list {
+			list[i] = o.read(r, elem, l, strconv.Itoa(i))
+		}
+		if l.empty != nil {
+			o.layouts[addressOf(list)] = l
+		}
+		return list
+	}
+	var v interface{}
+	r.Value(tag, &v, "")
+	return v
+}
+
+func (o *ordered) MarshalNBT(w *nbt.Writer) error {
+	l := o.layouts[addressOf(o.m)]
+	var names []string
+	known := make(map[string]bool)
+	if l != nil {
+		names = l.names
+		for _, name := range names {
+			known[name] = true
+		}
+	}
+	var added []string
+	for name := range o.m {
+		if !known[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	names = append(names[:len(names):len(names)], added...)
+
+	for _, name := range names {
+		v, ok := o.m[name]
+		if !ok {
+			continue // Deleted.
+		}
+		tag, err := tagOf(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		w.Entry(tag, name)
+		if err := o.write(w, v, l, name); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Writes the payload of v, which parent describes under key.
+func (o *ordered) write(w *nbt.Writer, v interface{}, parent *layout, key string) error {
+	switch v := v.(type) {
+	case int8:
+		w.Byte(v)
+	case int16:
+		w.Short(v)
+	case int32:
+		w.Int(v)
+	case int64:
+		w.Long(v)
+	case float32:
+		w.Float(v)
+	case float64:
+		w.Double(v)
+	case string:
+		w.String(v)
+	case []byte:
+		w.ByteArray(v)
+	case []int32:
+		w.IntArray(v)
+	case []int64:
+		w.LongArray(v)
+	case map[string]interface{}:
+		w.Compound(&ordered{v, o.layouts})
+	case []interface{}:
+		if len(v) == 0 {
+			w.List(parent.emptyElem(key), 0)
+			return nil
+		}
+		elem, err := tagOf(v[0])
+		if err != nil {
+			return err
+		}
+		l := o.layouts[addressOf(v)]
+		for i, el := range v[:w.List(elem, len(v))] {
+			if tag, err := tagOf(el); err != nil || tag != elem {
+				return fmt.Errorf("list of %s has a %T at index %d", elem, el, i)
+			}
+			if err := o.write(w, el, l, strconv.Itoa(i)); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the tag a value in a tree is written as.
+func tagOf(v interface{}) (nbt.Tag, error) {
+	switch v.(type) {
+	case int8:
+		return nbt.TagByte, nil
+	case int16:
+		return nbt.TagShort, nil
+	case int32:
+		return nbt.TagInt, nil
+	case int64:
+		return nbt.TagLong, nil
+	case float32:
+		return nbt.TagFloat, nil
+	case float64:
+		return nbt.TagDouble, nil
+	case string:
+		return nbt.TagString, nil
+	case []byte:
+		return nbt.TagByteArray, nil
+	case []int32:
+		return nbt.TagIntArray, nil
+	case []int64:
+		return nbt.TagLongArray, nil
+	case map[string]interface{}:
+		return nbt.TagCompound, nil
+	case []interface{}:
+		return nbt.TagList, nil
+	}
+	return nbt.TagEnd, fmt.Errorf("can't write a %T", v)
+}
+
+// Writes a file by writing a temporary file in the same directory and renaming
+// it, so readers see either the old contents or the new.
+func writeFile(name string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+func TestEdit(t *testing.T) {
+	type Item struct {
+		ID    string `nbt:"id"`
+		Count int8
+	}
+	var doc struct {
+		Zeta      int32
+		Empty     []int32
+		Counts    []int8
+		Inventory []Item
+		Alpha     string
+	}
+	doc.Empty = []int32{}
+	doc.Counts = []int8{2, 3}
+	doc.Inventory = []Item{{"minecraft:stone", 1}}
+	var buf bytes.Buffer
+	if err := nbt.Marshal(nbt.GZip, &buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "level.dat")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appended numbers take the tag of the list's elements, as set's take the
+	// tag of the numbers they replace.
+	err := edit(file, []string{
+		"append", "Counts", "1",
+		"set", "Inventory[].Count", "64",
+		"delete", "Alpha",
+		"set", "Beta", "{b:1b,a:[]}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nbt.DetectCompression(data) != nbt.GZip {
+		t.Error("Compression changed")
+	}
+	edited := &ordered{layouts: make(map[uintptr]*layout)}
+	if err := nbt.Unmarshal(nbt.GZip, bytes.NewReader(data), edited); err != nil {
+		t.Fatal(err)
+	}
+	names := edited.layouts[addressOf(edited.m)].names
+	if want := []string{"Zeta", "Empty", "Counts", "Inventory", "Beta"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Entries are in order %q, but expected %q", names, want)
+	}
+	if counts := edited.m["Counts"]; !reflect.DeepEqual(counts, []interface{}{int8(2), int8(3), int8(1)}) {
+		t.Errorf("Counts are %#v", counts)
+	}
+	item := edited.m["Inventory"].([]interface{})[0].(map[string]interface{})
+	if item["Count"] != int8(64) {
+		t.Errorf("Item is %#v", item)
+	}
+
+	// The empty list read from the file is still a list of TAG_Int; the one
+	// the edit made has no element type.
+	var raw map[string]nbt.RawTag
+	if err := nbt.Unmarshal(nbt.GZip, bytes.NewReader(data), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if p := raw["Empty"].Payload; !bytes.Equal(p, []byte{byte(nbt.TagInt), 0, 0, 0, 0}) {
+		t.Errorf("Empty list is % x", p)
+	}
+}
@@ -0,0 +1,224 @@
+// Command nbtgen writes Go struct types for the NBT documents in sample files.
+//
+// Usage:
+//
+//	nbtgen [flags] file...
+//
+// Every file is taken to be a sample of the same kind of document. nbtgen
+// infers the structure they share and prints Go source declaring a struct for
+// the root compound and one for each compound inside it, with nbt struct tags
+// for the tag names. Fields keep the order they have in the samples.
+//
+// The samples' schemas are merged as nbt.MergeSchemas does: a field found in
+// only some of them is optional and becomes a pointer, numbers of different
+// widths are widened to the widest, and a tag that is not always of the same
+// type becomes an interface{}. Lists of compounds merge all their elements into
+// one struct. Identical structs are only declared once. Lists of arrays become
+// []interface{}, as struct tags can't ask for their elements to be arrays.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+var (
+	pkg      = flag.String("package", "main", "`name` of the package to generate")
+	typeName = flag.String("type", "Root", "`name` of the root struct type")
+	dialect  = flag.String("dialect", "java", "binary `dialect` of the samples: java, bedrock or bedrock-network")
+	output   = flag.String("o", "", "write the source to `file` instead of standard output")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nbtgen [flags] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := generate(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "nbtgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(files []string) error {
+	d, err := nbt.ParseDialect(*dialect)
+	if err != nil {
+		return err
+	}
+	dec := nbt.Decoder{Dialect: d}
+
+	var root *nbt.Schema
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		s, err := dec.InferSchema(nbt.DetectCompression(data), bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		root = nbt.MergeSchemas(root, s)
+	}
+	if root.Tag != nbt.TagCompound {
+		return fmt.Errorf("the root tag is a %s, not a compound", root.Tag)
+	}
+
+	g := &generator{bodies: make(map[string]string), used: map[string]bool{*typeName: true}}
+	g.declare(*typeName, g.structBody(*typeName, root))
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by nbtgen from %s; DO NOT EDIT.\n\n", strings.Join(files, ", "))
+	fmt.Fprintf(&src, "package %s\n", *pkg)
+	// Types are declared after the types they use, so this puts the root
+	// first.
+	for i := len(g.decls) - 1; i >= 0; i-- {
+		fmt.Fprintf(&src, "\n%s\n", g.decls[i])
+	}
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return ioutil.WriteFile(*output, out, 0644)
+}
+
+type generator struct {
+	decls  []string
+	bodies map[string]string // Struct bodies to the names they were declared with.
+	used   map[string]bool
+}
+
+// Returns the body of the struct type for a compound, declaring the types it
+// uses.
+func (g *generator) structBody(name string, s *nbt.Schema) string {
+	var body bytes.Buffer
+	body.WriteString("struct {\n")
+	names := make(map[string]bool)
+	for _, f := range s.Fields {
+		field := goName(f.Name)
+		for i := 2; names[field]; i++ {
+			field = fmt.Sprintf("%s%d", goName(f.Name), i)
+		}
+		names[field] = true
+
+		typ, opts := g.goType(name+goName(f.Name), field, f.Schema)
+		if f.Optional {
+			// Marshal leaves out nil pointers.
+			typ = "*" + typ
+		}
+		fmt.Fprintf(&body, "\t%s %s", field, typ)
+		if field != f.Name || opts != "" {
+			fmt.Fprintf(&body, " `nbt:%q`", f.Name+opts)
+		}
+		body.WriteString("\n")
+	}
+	body.WriteString("}")
+	return body.String()
+}
+
+func (g *generator) declare(name, body string) {
+	g.bodies[body] = name
+	g.decls = append(g.decls, fmt.Sprintf("type %s %s", name, body))
+}
+
+// Returns the Go type for a tag and the struct tag options it needs. Struct
+// types are named after the field, or after the parent and the field if that
+// name is taken.
+func (g *generator) goType(long, short string, s *nbt.Schema) (string, string) {
+	switch s.Tag {
+	case nbt.TagByte:
+		return "int8", ""
+	case nbt.TagShort:
+		return "int16", ""
+	case nbt.TagInt:
+		return "int32", ""
+	case nbt.TagLong:
+		return "int64", ""
+	case nbt.TagFloat:
+		return "float32", ""
+	case nbt.TagDouble:
+		return "float64", ""
+	case nbt.TagString:
+		return "string", ""
+	case nbt.TagByteArray:
+		return "[]byte", ",array"
+	case nbt.TagIntArray:
+		return "[]int32", ",array"
+	case nbt.TagLongArray:
+		return "[]int64", ",array"
+
+	case nbt.TagList:
+		if s.Elem == nil {
+			return "[]interface{}", ""
+		}
+		elem, opts := g.goType(long, short, s.Elem)
+		if opts != "" {
+			// Options only apply to the field itself, so a list of arrays
+			// can't be typed; its elements are arrays when held in interfaces.
+			return "[]interface{}", ""
+		}
+		return "[]" + elem, ""
+
+	case nbt.TagCompound:
+		name := short
+		if g.used[name] {
+			name = long
+		}
+		for i := 2; g.used[name]; i++ {
+			name = fmt.Sprintf("%s%d", long, i)
+		}
+		g.used[name] = true // Before the body, so nested types can't take it.
+
+		body := g.structBody(name, s)
+		if existing, ok := g.bodies[body]; ok {
+			delete(g.used, name)
+			return existing, ""
+		}
+		g.declare(name, body)
+		return name, ""
+	}
+	return "interface{}", ""
+}
+
+// Words that Go style writes in capitals.
+var initialisms = map[string]string{"id": "ID", "uuid": "UUID", "ip": "IP", "url": "URL"}
+
+// Returns an exported Go identifier for a tag name, such as FoodLevel for
+// foodLevel or MinecraftStone for minecraft:stone.
+func goName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, w := range words {
+		if s, ok := initialisms[strings.ToLower(w)]; ok && strings.ToLower(w) == w {
+			b.WriteString(s)
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	if b.Len() == 0 || !unicode.IsUpper([]rune(b.String())[0]) {
+		return "F" + b.String()
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestGolden(t *testing.T) {
+	*output = filepath.Join(t.TempDir(), "bigtest.go")
+	defer func() { *output = "" }()
+	if err := generate([]string{"../../testcases/bigtest.nbt"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(*output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "bigtest.go.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Generated:\n%s\nbut expected:\n%s", got, want)
+	}
+}
+
+func TestOptionalAndNestedArrays(t *testing.T) {
+	s := &nbt.Schema{Tag: nbt.TagCompound, Fields: []nbt.Field{
+		{Name: "CustomName", Schema: &nbt.Schema{Tag: nbt.TagString}, Optional: true},
+		{Name: "Ranges", Schema: &nbt.Schema{Tag: nbt.TagList, Elem: &nbt.Schema{Tag: nbt.TagIntArray}}},
+		{Name: "Grid", Schema: &nbt.Schema{Tag: nbt.TagList, Elem: &nbt.Schema{Tag: nbt.TagList, Elem: &nbt.Schema{Tag: nbt.TagInt}}}},
+	}}
+	g := &generator{bodies: make(map[string]string), used: map[string]bool{"Root": true}}
+	got := g.structBody("Root", s)
+	want := "struct {\n\tCustomName *string\n\tRanges []interface{}\n\tGrid [][]int32\n}"
+	if got != want {
+		t.Errorf("Generated %q, but expected %q", got, want)
+	}
+}
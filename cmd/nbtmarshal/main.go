@@ -0,0 +1,777 @@
+// Command nbtmarshal generates MarshalNBT and UnmarshalNBT methods, which
+// encode and decode struct types without reflection.
+//
+// Usage:
+//
+//	nbtmarshal [flags] [dir]
+//
+// It is meant to be run by go generate, from a comment in the package:
+//
+//	//go:generate nbtmarshal
+//
+// Methods are generated for the struct types in the package whose doc comment
+// has an nbt:generate line, and for the types named by -type:
+//
+//	// A Player is the contents of a player's .dat file.
+//	//
+//	//nbt:generate
+//	type Player struct { ... }
+//
+// The methods honor nbt struct tags exactly as Marshal and Unmarshal do. Fields
+// of types the generated code doesn't handle itself, like maps, interfaces,
+// time.Time and fields with options other than array, are still encoded with
+// reflection, and nbtmarshal says which ones they are. Struct fields need
+// methods of their own to avoid reflection.
+//
+// The methods go in pkg_nbt.go, where pkg is the package name, and tests that
+// check them against the reflective encoding of random values go in
+// pkg_nbt_test.go.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const nbtPath = "github.com/Nightgunner5/go.nbt"
+
+var (
+	typeNames = flag.String("type", "", "comma-separated `names` of more types to generate methods for")
+	output    = flag.String("o", "", "`file` to write the methods to (default pkg_nbt.go)")
+	tests     = flag.Bool("tests", true, "also write tests that compare the methods with reflection")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nbtmarshal [flags] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "nbtmarshal: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string) error {
+	pkg, files, err := load(dir)
+	if err != nil {
+		return err
+	}
+
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, pkg.Name()+"_nbt.go")
+	}
+	named, err := targets(pkg, files)
+	if err != nil {
+		return err
+	}
+	if len(named) == 0 {
+		return fmt.Errorf("no types to generate methods for; mark them with //nbt:generate or use -type")
+	}
+
+	g := &generator{pkg: pkg, imports: map[string]string{nbtPath: "nbt"}, targets: make(map[*types.TypeName]bool)}
+	for _, t := range named {
+		g.targets[t.Obj()] = true
+	}
+	for _, t := range named {
+		if err := g.generate(t); err != nil {
+			return err
+		}
+	}
+	if err := write(out, g.file(pkg.Name(), g.body.Bytes())); err != nil {
+		return err
+	}
+	if *tests {
+		test := strings.TrimSuffix(out, ".go") + "_test.go"
+		if err := write(test, g.testFile(pkg.Name(), named)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses and type checks the package in dir, leaving out files nbtmarshal
+// wrote.
+func load(dir string) (*types.Package, []*ast.File, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isGenerated(f) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check(bp.ImportPath, fset, files, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pkg, files, nil
+}
+
+const header = "// Code generated by nbtmarshal; DO NOT EDIT."
+
+func isGenerated(f *ast.File) bool {
+	for _, c := range f.Comments {
+		for _, line := range c.List {
+			if line.Text == header {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the types marked with nbt:generate or named by -type, in the order
+// they are declared.
+func targets(pkg *types.Package, files []*ast.File) ([]*types.Named, error) {
+	wanted := make(map[string]bool)
+	if *typeNames != "" {
+		for _, name := range strings.Split(*typeNames, ",") {
+			wanted[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var named []*types.Named
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				doc := spec.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				if !wanted[spec.Name.Name] && !hasDirective(doc) {
+					continue
+				}
+				delete(wanted, spec.Name.Name)
+
+				t := pkg.Scope().Lookup(spec.Name.Name).Type().(*types.Named)
+				if _, ok := t.Underlying().(*types.Struct); !ok {
+					return nil, fmt.Errorf("%s is not a struct type", spec.Name.Name)
+				}
+				named = append(named, t)
+			}
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("no type named %s", name)
+	}
+	return named, nil
+}
+
+func hasDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if c.Text == "//nbt:generate" {
+			return true
+		}
+	}
+	return false
+}
+
+func write(name string, src []byte) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("formatting %s: %v\n%s", name, err, src)
+	}
+	return ioutil.WriteFile(name, formatted, 0644)
+}
+
+type generator struct {
+	pkg     *types.Package
+	imports map[string]string // Paths to names.
+	targets map[*types.TypeName]bool
+	body    bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+// Returns a type as it is written in the package, noting the imports it
+// needs.
+func (g *generator) typeString(t types.Type) string {
+	qualify := packageName(g.pkg)
+	return types.TypeString(t, func(p *types.Package) string {
+		if name := qualify(p); name != "" {
+			g.imports[p.Path()] = name
+			return name
+		}
+		return ""
+	})
+}
+
+// Qualifies types in packages other than pkg with the package name.
+func packageName(pkg *types.Package) types.Qualifier {
+	return func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Name()
+	}
+}
+
+func (g *generator) file(pkgName string, body []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\npackage %s\n\nimport (\n", header, pkgName)
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if name := g.imports[path]; name != filepath.Base(path) {
+			fmt.Fprintf(&b, "\t%s %q\n", name, path)
+		} else {
+			fmt.Fprintf(&b, "\t%q\n", path)
+		}
+	}
+	b.WriteString(")\n")
+	b.Write(body)
+	return b.Bytes()
+}
+
+// How a value is encoded.
+type kind int
+
+const (
+	reflected kind = iota // By the reflective encoder.
+	boolKind
+	byteKind
+	shortKind
+	intKind
+	longKind
+	floatKind
+	doubleKind
+	stringKind
+	byteArrayKind
+	intArrayKind
+	longArrayKind
+	listKind
+	compoundKind
+)
+
+var kindTags = [...]string{
+	boolKind: "TagByte", byteKind: "TagByte", shortKind: "TagShort", intKind: "TagInt",
+	longKind: "TagLong", floatKind: "TagFloat", doubleKind: "TagDouble", stringKind: "TagString",
+	byteArrayKind: "TagByteArray", intArrayKind: "TagIntArray", longArrayKind: "TagLongArray",
+	listKind: "TagList", compoundKind: "TagCompound",
+}
+
+// The Writer and Reader methods for each kind of payload.
+var kindMethods = [...]string{
+	boolKind: "Bool", byteKind: "Byte", shortKind: "Short", intKind: "Int",
+	longKind: "Long", floatKind: "Float", doubleKind: "Double", stringKind: "String",
+	byteArrayKind: "ByteArray", intArrayKind: "IntArray", longArrayKind: "LongArray",
+}
+
+// The Go types the Writer and Reader methods take and return.
+var kindTypes = [...]string{
+	boolKind: "bool", byteKind: "int8", shortKind: "int16", intKind: "int32",
+	longKind: "int64", floatKind: "float32", doubleKind: "float64", stringKind: "string",
+	byteArrayKind: "[]byte", intArrayKind: "[]int32", longArrayKind: "[]int64",
+}
+
+// Types the reflective encoder treats specially, by package path and name.
+var special = map[string]bool{
+	"time.Time":         true,
+	nbtPath + ".UUID":   true,
+	nbtPath + ".RawTag": true,
+}
+
+// Returns how values of type t are encoded, and for lists, how their elements
+// are. It mirrors typeTag in the nbt package.
+func (g *generator) kindOf(t types.Type, array bool) (kind, types.Type, error) {
+	if n, ok := t.(*types.Named); ok {
+		obj := n.Obj()
+		if obj.Pkg() != nil && special[obj.Pkg().Path()+"."+obj.Name()] {
+			return reflected, nil, nil
+		}
+	}
+	switch {
+	case g.targets[typeName(t)] || (hasMethod(t, "MarshalNBT") && hasMethod(t, "UnmarshalNBT")):
+		return compoundKind, nil, nil
+	case hasMethod(t, "MarshalText") || hasMethod(t, "UnmarshalText") ||
+		hasMethod(t, "MarshalBinary") || hasMethod(t, "UnmarshalBinary") ||
+		hasMethod(t, "MarshalNBT") || hasMethod(t, "UnmarshalNBT"):
+		return reflected, nil, nil
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Bool:
+			return boolKind, nil, nil
+		case types.Int8, types.Uint8:
+			return byteKind, nil, nil
+		case types.Int16, types.Uint16:
+			return shortKind, nil, nil
+		case types.Int32, types.Uint32:
+			return intKind, nil, nil
+		case types.Int64, types.Uint64:
+			return longKind, nil, nil
+		case types.Float32:
+			return floatKind, nil, nil
+		case types.Float64:
+			return doubleKind, nil, nil
+		case types.String:
+			return stringKind, nil, nil
+		case types.Int, types.Uint:
+			return 0, nil, fmt.Errorf("int and uint types are not supported for portability reasons; try int32 or uint32")
+		}
+
+	case *types.Slice:
+		elem := u.Elem()
+		if b, ok := elem.(*types.Basic); ok && array {
+			// Only these element types can be passed to the Writer as they
+			// are; other arrays are left to reflection.
+			switch b.Kind() {
+			case types.Uint8:
+				return byteArrayKind, nil, nil
+			case types.Int32:
+				return intArrayKind, nil, nil
+			case types.Int64:
+				return longArrayKind, nil, nil
+			}
+		}
+		if array {
+			return reflected, nil, nil
+		}
+		k, _, err := g.kindOf(elem, false)
+		if err != nil || k == reflected {
+			return reflected, nil, err
+		}
+		return listKind, elem, nil
+	}
+	return reflected, nil, nil
+}
+
+func typeName(t types.Type) *types.TypeName {
+	if n, ok := t.(*types.Named); ok {
+		return n.Obj()
+	}
+	return nil
+}
+
+// Reports whether t or *t has a method.
+func hasMethod(t types.Type, name string) bool {
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), false, nil, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+// The options that may follow a name in an nbt struct tag.
+var knownOptions = map[string]bool{"array": true, "ticks": true, "millis": true, "mostleast": true}
+
+// Splits an nbt struct tag the way the nbt package does.
+func parseTag(tag string) (string, []string) {
+	var opts []string
+	for {
+		i := strings.LastIndexByte(tag, ',')
+		if i < 0 || !knownOptions[tag[i+1:]] {
+			return tag, opts
+		}
+		opts = append(opts, tag[i+1:])
+		tag = tag[:i]
+	}
+}
+
+type field struct {
+	name  string // In NBT.
+	goVar string // In Go, e.g. x.Pos.
+	typ   types.Type
+	opts  []string
+	kind  kind
+	elem  types.Type
+}
+
+// Returns the fields of a struct type as the nbt package sees them.
+func (g *generator) fields(t *types.Named) ([]field, error) {
+	s := t.Underlying().(*types.Struct)
+	var fields []field
+	seen := make(map[string]bool)
+	for i := 0; i < s.NumFields(); i++ {
+		v := s.Field(i)
+		if v.Embedded() {
+			continue
+		}
+		name, opts := parseTag(reflect.StructTag(s.Tag(i)).Get("nbt"))
+		if name == "" {
+			name = v.Name()
+		}
+		if name == "-" {
+			continue
+		}
+		if !v.Exported() {
+			return nil, fmt.Errorf("%s.%s is unexported; tag it nbt:\"-\"", t.Obj().Name(), v.Name())
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s has multiple fields with name %q", t.Obj().Name(), name)
+		}
+		seen[name] = true
+
+		f := field{name: name, goVar: "x." + v.Name(), typ: v.Type(), opts: opts}
+		array := false
+		for _, opt := range opts {
+			switch opt {
+			case "array":
+				array = true
+			case "mostleast":
+				return nil, fmt.Errorf("%s.%s: the mostleast option is not supported; leave %s to reflection", t.Obj().Name(), v.Name(), t.Obj().Name())
+			}
+		}
+		var err error
+		if len(opts) > 0 && !(len(opts) == 1 && array) {
+			f.kind = reflected
+		} else if f.kind, f.elem, err = g.kindOf(f.typ, array); err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", t.Obj().Name(), v.Name(), err)
+		}
+		if f.kind == reflected {
+			fmt.Fprintf(os.Stderr, "nbtmarshal: %s.%s (%s) is encoded with reflection\n", t.Obj().Name(), v.Name(), types.TypeString(f.typ, packageName(g.pkg)))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (g *generator) generate(t *types.Named) error {
+	fields, err := g.fields(t)
+	if err != nil {
+		return err
+	}
+	name := t.Obj().Name()
+
+	g.printf("\nfunc (x *%s) MarshalNBT(w *nbt.Writer) error {\n", name)
+	for _, f := range fields {
+		if f.kind == reflected {
+			g.printf("w.Value(%q, %s, %q)\n", f.name, f.goVar, strings.Join(f.opts, ","))
+			continue
+		}
+		g.printf("w.Entry(nbt.%s, %q)\n", kindTags[f.kind], f.name)
+		g.writePayload(f.goVar, f.typ, f.kind, f.elem, 0)
+	}
+	g.printf("return nil\n}\n")
+
+	g.printf("\nfunc (x *%s) UnmarshalNBT(r *nbt.Reader) error {\n", name)
+	g.printf("for {\nname, tag := r.Entry()\nif tag == nbt.TagEnd {\nreturn nil\n}\nswitch name {\n")
+	for _, f := range fields {
+		g.printf("case %q:\n", f.name)
+		if f.kind == reflected {
+			g.printf("r.Value(tag, &%s, %q)\n", f.goVar, strings.Join(f.opts, ","))
+			continue
+		}
+		g.readPayload(f.goVar, f.typ, f.kind, f.elem, "tag", 0)
+	}
+	g.printf("default:\nr.Unhandled(tag)\n}\n}\n}\n")
+	return nil
+}
+
+// Writes the code that writes the payload of v.
+func (g *generator) writePayload(v string, t types.Type, k kind, elem types.Type, depth int) {
+	switch k {
+	case compoundKind:
+		g.printf("w.Compound(&%s)\n", v)
+	case listKind:
+		ek, eelem, _ := g.kindOf(elem, false)
+		i := fmt.Sprintf("i%d", depth)
+		g.printf("for %s := range %s[:w.List(nbt.%s, len(%s))] {\n", i, v, kindTags[ek], v)
+		g.writePayload(v+"["+i+"]", elem, ek, eelem, depth+1)
+		g.printf("}\n")
+	default:
+		g.printf("w.%s(%s)\n", kindMethods[k], g.convert(v, t, kindTypes[k]))
+	}
+}
+
+// Writes the code that reads a payload with the given tag into v.
+func (g *generator) readPayload(v string, t types.Type, k kind, elem types.Type, tag string, depth int) {
+	switch k {
+	case compoundKind:
+		g.printf("r.Compound(%s, &%s)\n", tag, v)
+	case listKind:
+		ek, eelem, _ := g.kindOf(elem, false)
+		e, n, i := fmt.Sprintf("elem%d", depth), fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth)
+		g.printf("%s, %s := r.List(%s)\n", e, n, tag)
+		// As with reflection, an empty list leaves a nil slice nil.
+		g.printf("if %s == 0 {\n%s = %s[:0]\n} else {\n%s = make(%s, %s)\n}\n", n, v, v, v, g.typeString(t), n)
+		g.printf("for %s := range %s {\n", i, v)
+		g.readPayload(v+"["+i+"]", elem, ek, eelem, e, depth+1)
+		g.printf("}\n")
+	default:
+		g.printf("%s = %s\n", v, g.convertBack(fmt.Sprintf("r.%s(%s)", kindMethods[k], tag), t, kindTypes[k]))
+	}
+}
+
+// Converts v of type t to the type a Writer method takes.
+func (g *generator) convert(v string, t types.Type, to string) string {
+	if g.typeString(t) == to {
+		return v
+	}
+	if strings.HasPrefix(to, "[]") {
+		to = "(" + to + ")"
+	}
+	return to + "(" + v + ")"
+}
+
+// Converts v, returned by a Reader method, to type t.
+func (g *generator) convertBack(v string, t types.Type, from string) string {
+	s := g.typeString(t)
+	if s == from {
+		return v
+	}
+	if strings.HasPrefix(s, "[]") || strings.HasPrefix(s, "*") {
+		s = "(" + s + ")"
+	}
+	return s + "(" + v + ")"
+}
+
+func (g *generator) testFile(pkgName string, named []*types.Named) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\npackage %s\n\n", header, pkgName)
+	fmt.Fprintf(&b, "import (\n\t\"math/rand\"\n\t\"reflect\"\n\t\"testing\"\n\t\"time\"\n\n\tnbt %q\n)\n", nbtPath)
+	for _, t := range named {
+		fmt.Fprintf(&b, `
+func Test%[1]sNBT(t *testing.T) {
+	nbtmarshalCheck(t, reflect.TypeOf(%[1]s{}))
+}
+`, t.Obj().Name())
+	}
+	b.WriteString("\n// The types with generated methods.\nvar nbtmarshalGenerated = map[reflect.Type]bool{\n")
+	for _, t := range named {
+		fmt.Fprintf(&b, "\treflect.TypeOf(%s{}): true,\n", t.Obj().Name())
+	}
+	b.WriteString("}\n")
+	b.WriteString(checkFunc)
+	return b.Bytes()
+}
+
+const checkFunc = `
+// Checks that random values of a type with generated methods are encoded and
+// decoded just as they are with reflection, in every dialect. Reflection is
+// given copies whose types have the same fields and tags but no methods, down
+// to the last nested struct.
+func nbtmarshalCheck(t *testing.T, generated reflect.Type) {
+	reflective := nbtmarshalPlain(generated, make(map[reflect.Type]bool))
+	rand := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		v := reflect.New(generated).Elem()
+		nbtmarshalFill(v, rand, 0)
+		plain := reflect.New(reflective).Elem()
+		nbtmarshalCopy(plain, v)
+
+		for _, dialect := range []nbt.Dialect{nbt.Java, nbt.Bedrock, nbt.BedrockNetwork} {
+			enc := nbt.Encoder{Dialect: dialect}
+			want, wantErr := enc.AppendMarshal(nil, plain.Interface())
+			got, err := enc.AppendMarshal(nil, v.Interface())
+			if err != nil || wantErr != nil {
+				if err == nil || wantErr == nil || err.Error() != wantErr.Error() {
+					t.Fatalf("%v: MarshalNBT gave error %v, but reflection gave %v", dialect, err, wantErr)
+				}
+				continue
+			}
+
+			// Maps are written in any order, so the documents are compared
+			// as trees.
+			dec := nbt.Decoder{Dialect: dialect}
+			var wantTree, gotTree interface{}
+			if _, err := dec.UnmarshalBytes(want, &wantTree); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.UnmarshalBytes(got, &gotTree); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotTree, wantTree) {
+				t.Fatalf("%v: MarshalNBT wrote %v, but reflection wrote %v", dialect, gotTree, wantTree)
+			}
+
+			decoded, plainDecoded := reflect.New(generated), reflect.New(reflective)
+			if _, err := dec.UnmarshalBytes(want, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.UnmarshalBytes(want, plainDecoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			converted := reflect.New(generated).Elem()
+			nbtmarshalCopy(converted, plainDecoded.Elem())
+			if !reflect.DeepEqual(decoded.Elem().Interface(), converted.Interface()) {
+				t.Fatalf("%v: UnmarshalNBT read %+v, but reflection read %+v", dialect, decoded.Elem(), plainDecoded.Elem())
+			}
+		}
+	}
+}
+
+// Returns t with the types that have generated methods, and the types holding
+// them, replaced by unnamed types of the same shape, which have no methods.
+// A type that holds itself keeps its methods below the first level.
+func nbtmarshalPlain(t reflect.Type, visiting map[reflect.Type]bool) reflect.Type {
+	switch t.Kind() {
+	case reflect.Struct:
+		if visiting[t] {
+			return t
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		changed := nbtmarshalGenerated[t]
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Anonymous {
+				continue
+			}
+			plain := nbtmarshalPlain(f.Type, visiting)
+			changed = changed || plain != f.Type
+			f.Type, f.Index, f.Offset = plain, nil, 0
+			fields = append(fields, f)
+		}
+		if changed {
+			return reflect.StructOf(fields)
+		}
+	case reflect.Slice:
+		if elem := nbtmarshalPlain(t.Elem(), visiting); elem != t.Elem() {
+			return reflect.SliceOf(elem)
+		}
+	case reflect.Array:
+		if elem := nbtmarshalPlain(t.Elem(), visiting); elem != t.Elem() {
+			return reflect.ArrayOf(t.Len(), elem)
+		}
+	case reflect.Ptr:
+		if elem := nbtmarshalPlain(t.Elem(), visiting); elem != t.Elem() {
+			return reflect.PointerTo(elem)
+		}
+	case reflect.Map:
+		if elem := nbtmarshalPlain(t.Elem(), visiting); elem != t.Elem() {
+			return reflect.MapOf(t.Key(), elem)
+		}
+	}
+	return t
+}
+
+// Copies src to dst, where one's type is the other's as nbtmarshalPlain gives
+// it. Struct fields are matched by name.
+func nbtmarshalCopy(dst, src reflect.Value) {
+	if dst.Type() == src.Type() {
+		dst.Set(src)
+		return
+	}
+	switch src.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			from := src.FieldByName(dst.Type().Field(i).Name)
+			if dst.Field(i).CanSet() && from.IsValid() {
+				nbtmarshalCopy(dst.Field(i), from)
+			}
+		}
+	case reflect.Slice:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+			for i := 0; i < src.Len(); i++ {
+				nbtmarshalCopy(dst.Index(i), src.Index(i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			nbtmarshalCopy(dst.Index(i), src.Index(i))
+		}
+	case reflect.Ptr:
+		if !src.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+			nbtmarshalCopy(dst.Elem(), src.Elem())
+		}
+	case reflect.Map:
+		if !src.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+			for iter := src.MapRange(); iter.Next(); {
+				el := reflect.New(dst.Type().Elem()).Elem()
+				nbtmarshalCopy(el, iter.Value())
+				dst.SetMapIndex(iter.Key(), el)
+			}
+		}
+	}
+}
+
+// Sets the numbers, strings and slices in v to random values.
+func nbtmarshalFill(v reflect.Value, rand *rand.Rand, depth int) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(rand.Intn(2) == 0)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(rand.Uint64()))
+		v.SetInt(v.Int() >> uint(64-8*v.Type().Size()))
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			// Whole ticks, which the ticks and millis options can store.
+			v.SetInt(v.Int() / int64(nbt.Tick) * int64(nbt.Tick))
+		}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(rand.Uint64() >> uint(64-8*v.Type().Size()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(rand.NormFloat64() * 1000)
+	case reflect.String:
+		runes := make([]rune, rand.Intn(20))
+		for i := range runes {
+			runes[i] = rune(rand.Intn(0x10000))
+		}
+		v.SetString(string(runes))
+	case reflect.Slice:
+		if depth > 3 {
+			return
+		}
+		n := rand.Intn(5)
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			nbtmarshalFill(v.Index(i), rand, depth+1)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				nbtmarshalFill(v.Field(i), rand, depth+1)
+			}
+		}
+	}
+}
+`
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Generates methods for the package in testdata/player, then builds it and
+// runs the tests nbtmarshal wrote, which check the methods against reflection.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test on the generated package")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+
+	dir := filepath.Join(t.TempDir(), "player")
+	copyModule(t, dir)
+	if err := run(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"player_nbt.go", "player_nbt_test.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// A second run replaces the generated files rather than reading them.
+	if err := run(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goTool, "vet", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go vet: %v\n%s", err, out)
+	}
+	cmd = exec.Command(goTool, "test", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test: %v\n%s", err, out)
+	}
+}
+
+// Copies the fixture to dir and makes it a module whose copy of the nbt
+// package, in a sibling directory, is this one, so that it builds the same
+// with or without GOPATH mode.
+func copyModule(t *testing.T, dir string) {
+	nbtDir := filepath.Join(filepath.Dir(dir), "nbt")
+	copyFiles(t, filepath.Join("testdata", "player"), dir)
+	copyFiles(t, filepath.Join("..", ".."), nbtDir)
+	writeFile(t, filepath.Join(nbtDir, "go.mod"), "module "+nbtPath+"\n\ngo 1.21\n")
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/player\n\ngo 1.21\n\n"+
+		"require "+nbtPath+" v0.0.0\n\nreplace "+nbtPath+" => ../nbt\n")
+}
+
+// Copies the Go files in from, other than tests, to the directory to.
+func copyFiles(t *testing.T, from, to string) {
+	names, err := filepath.Glob(filepath.Join(from, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(to, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(to, filepath.Base(name)), string(src))
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,32 @@
+// Package player is a fixture for the nbtmarshal tests, with a field of each
+// kind the generated code handles and some it leaves to reflection.
+package player
+
+import "time"
+
+//nbt:generate
+type Player struct {
+	Name       string
+	Health     float32
+	Score      int32
+	XP         uint16
+	OnGround   bool
+	Pos        []float64
+	Inventory  []Item
+	Skin       []byte  `nbt:"skin,array"`
+	Seen       []int32 `nbt:",array"`
+	Tracked    []int64 `nbt:"tracked,array"`
+	Path       []int32
+	Grid       [][]int8
+	Attributes map[string]float64
+	Cooldown   time.Duration `nbt:"cooldown,ticks"`
+	Tagged     string        `nbt:"a,b"`
+	Scratch    int32         `nbt:"-"`
+}
+
+//nbt:generate
+type Item struct {
+	ID    string `nbt:"id"`
+	Count int8
+	Lore  []string
+}
@@ -51,7 +51,7 @@ func (d *debugState) printf(indent int, format string, args ...interface{}) {
 
 func (d *debugState) debug(indent int) bool {
 	name, tag := d.readTag()
-	if tag == tagEnd {
+	if tag == TagEnd {
 		d.printf(indent, "%s", tag)
 		return false
 	}
@@ -72,7 +72,7 @@ func (d *debugState) readTag() (string, Tag) {
 	var tag Tag
 	d.r(&tag)
 
-	if tag == tagEnd {
+	if tag == TagEnd {
 		return "", tag
 	}
 
@@ -86,47 +86,47 @@ func (d *debugState) readString() string {
 	d.r(&length)
 
 	value := make([]byte, length)
-	_, err := d.in.Read(value)
+	_, err := io.ReadFull(d.in, value)
 	if err != nil {
 		panic(err)
 	}
 
-	return string(value)
+	return decodeMUTF8(value)
 }
 
 func (d *debugState) debugValue(indent int, tag Tag) {
 	switch tag {
-	case tagByte:
+	case TagByte:
 		var value uint8
 		d.r(&value)
 		d.printf(indent, "0x%02x", value)
 
-	case tagShort:
+	case TagShort:
 		var value uint16
 		d.r(&value)
 		d.printf(indent, "0x%04x", value)
 
-	case tagInt:
+	case TagInt:
 		var value uint32
 		d.r(&value)
 		d.printf(indent, "0x%08x", value)
 
-	case tagLong:
+	case TagLong:
 		var value uint64
 		d.r(&value)
 		d.printf(indent, "0x%016x", value)
 
-	case tagFloat:
+	case TagFloat:
 		var value float32
 		d.r(&value)
 		d.printf(indent, "%#v", value)
 
-	case tagDouble:
+	case TagDouble:
 		var value float64
 		d.r(&value)
 		d.printf(indent, "%#v", value)
 
-	case tagByteArray:
+	case TagByteArray:
 		var length uint32
 		d.r(&length)
 		value := make([]byte, length)
@@ -134,12 +134,12 @@ func (d *debugState) debugValue(indent int, tag Tag) {
 		d.in.Read(value)
 		d.printf(indent, "Value: %#v", value)
 
-	case tagString:
+	case TagString:
 		value := d.readString()
 		d.printf(indent, "Length: %d", len(value))
 		d.printf(indent, "Value: %s", value)
 
-	case tagList:
+	case TagList:
 		var inner Tag
 		d.r(&inner)
 		var length uint32
@@ -155,29 +155,29 @@ func (d *debugState) debugValue(indent int, tag Tag) {
 
 		d.printf(indent, "}")
 
-	case tagCompound:
+	case TagCompound:
 		d.printf(indent, "Values: {")
 		for d.debug(indent + 1) {
 		}
 		d.printf(indent, "}")
 
-	case tagIntArray:
+	case TagIntArray:
 		var length uint32
 		d.r(&length)
 		d.printf(indent, "Length: %d", length)
 		d.printf(indent, "Values: {")
 		for i := uint32(0); i < length; i++ {
-			d.debugValue(indent+1, tagInt)
+			d.debugValue(indent+1, TagInt)
 		}
 		d.printf(indent, "}")
 
-	case tagLongArray:
+	case TagLongArray:
 		var length uint32
 		d.r(&length)
 		d.printf(indent, "Length: %d", length)
 		d.printf(indent, "Values: {")
 		for i := uint32(0); i < length; i++ {
-			d.debugValue(indent+1, tagLong)
+			d.debugValue(indent+1, TagLong)
 		}
 		d.printf(indent, "}")
 
@@ -3,28 +3,117 @@ package nbt
 import (
 	"compress/gzip"
 	"compress/zlib"
+	"encoding"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"time"
+	"unicode/utf8"
+	"unsafe"
 )
 
-func Unmarshal(compression Compression, in io.Reader, v interface{}) (err error) {
+func Unmarshal(compression Compression, in io.Reader, v interface{}) error {
+	return new(Decoder).Unmarshal(compression, in, v)
+}
+
+// Decodes an uncompressed NBT value from the start of data and returns the
+// number of bytes that made up the value.
+func UnmarshalBytes(data []byte, v interface{}) (int, error) {
+	return new(Decoder).UnmarshalBytes(data, v)
+}
+
+// A Decoder holds options for decoding. The zero value decodes exactly like
+// Unmarshal and UnmarshalBytes.
+type Decoder struct {
+	// The flavour of NBT to read. The default is Java.
+	Dialect Dialect
+
+	// If NoCopy is set, UnmarshalBytes does not copy byte arrays and strings
+	// out of its input: []byte values alias the input buffer and strings point
+	// into it. The caller must not modify the buffer while the decoded value is
+	// in use. It has no effect when decoding from an io.Reader.
+	NoCopy bool
+
+	// Normally numbers must be decoded into a Go type of exactly the tag's
+	// width. If ConvertNumbers is set, any integer tag can be decoded into any
+	// integer, float or bool, including int and uint, and TAG_Float and
+	// TAG_Double into either float type. Values that don't fit exactly cause a
+	// *ConversionError.
+	ConvertNumbers bool
+
+	// If not nil, compounds decoded into interface values are given the
+	// concrete type registered for their discriminator.
+	Registry *Registry
+}
+
+func (dec *Decoder) Unmarshal(compression Compression, in io.Reader, v interface{}) error {
+	_, err := dec.UnmarshalNamed(compression, in, v)
+	return err
+}
+
+// Like Unmarshal, but also returns the name of the root tag.
+func UnmarshalNamed(compression Compression, in io.Reader, v interface{}) (string, error) {
+	return new(Decoder).UnmarshalNamed(compression, in, v)
+}
+
+func (dec *Decoder) UnmarshalNamed(compression Compression, in io.Reader, v interface{}) (name string, err error) {
+	defer recoverError(&err)
+	name = newDecodeState(dec).init(compression, in).unmarshal(v)
+	return
+}
+
+func (dec *Decoder) UnmarshalBytes(data []byte, v interface{}) (n int, err error) {
+	var d *decodeState
 	defer func() {
-		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
-			}
+		if d != nil {
+			n = d.off
 		}
 	}()
-	new(decodeState).init(compression, in).unmarshal(v)
+	defer recoverError(&err)
+	d = newDecodeState(dec)
+	d.data = data
+	d.unmarshal(v)
 	return
 }
 
+// Turns a panic from inside the package into an error.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		if s, ok := r.(string); ok {
+			*err = errors.New(s)
+		} else {
+			*err = r.(error)
+		}
+	}
+}
+
+// Adds a line of context to a panic value on its way up the stack, keeping the
+// original error available to errors.As.
+func annotate(r interface{}, format string, args ...interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	return fmt.Errorf("%w"+format, append([]interface{}{err}, args...)...)
+}
+
 type decodeState struct {
-	in io.Reader
+	*Decoder
+	order byteOrder
+
+	in   io.Reader
+	data []byte // The input when decoding from memory; in is nil.
+	off  int    // The number of bytes consumed so far.
+
+	scratch  [8]byte
+	recorded []byte // If not nil, everything read from in is appended to it.
+}
+
+func newDecodeState(dec *Decoder) *decodeState {
+	return &decodeState{Decoder: dec, order: dec.Dialect.byteOrder()}
 }
 
 func (d *decodeState) init(compression Compression, in io.Reader) *decodeState {
@@ -54,24 +143,134 @@ func (d *decodeState) init(compression Compression, in io.Reader) *decodeState {
 	return d
 }
 
-func (d *decodeState) unmarshal(v interface{}) {
-	_, tag := d.readTag()
+// Decodes a whole document and returns the root tag's name.
+func (d *decodeState) unmarshal(v interface{}) string {
+	name, tag := d.readTag()
 	d.readValue(tag, reflect.ValueOf(v).Elem())
+	return name
 }
 
-func (d *decodeState) r(i interface{}) {
-	err := binary.Read(d.in, binary.BigEndian, i)
+// Returns the next n bytes of input. Unless the input is in memory, the
+// result is only valid until the next call.
+func (d *decodeState) next(n int) []byte {
+	if d.in == nil {
+		if n > len(d.data)-d.off {
+			panic(io.ErrUnexpectedEOF)
+		}
+		b := d.data[d.off : d.off+n : d.off+n]
+		d.off += n
+		return b
+	}
+
+	var b []byte
+	if n <= len(d.scratch) {
+		b = d.scratch[:n]
+	} else {
+		b = make([]byte, n)
+	}
+	_, err := io.ReadFull(d.in, b)
 	if err != nil {
 		panic(err)
 	}
+	d.off += n
+	if d.recorded != nil {
+		d.recorded = append(d.recorded, b...)
+	}
+	return b
+}
+
+func (d *decodeState) readU8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *decodeState) readU16() uint16 {
+	return d.order.Uint16(d.next(2))
+}
+
+func (d *decodeState) readU32() uint32 {
+	return d.order.Uint32(d.next(4))
+}
+
+func (d *decodeState) readU64() uint64 {
+	return d.order.Uint64(d.next(8))
+}
+
+// Reads the payload of a TAG_Int or an element of a TAG_Int_Array.
+func (d *decodeState) readInt32() uint32 {
+	if d.Dialect == BedrockNetwork {
+		return uint32(unzigzag(d.readUvarint(5)))
+	}
+	return d.readU32()
+}
+
+// Reads the payload of a TAG_Long or an element of a TAG_Long_Array.
+func (d *decodeState) readInt64() uint64 {
+	if d.Dialect == BedrockNetwork {
+		return uint64(unzigzag(d.readUvarint(10)))
+	}
+	return d.readU64()
+}
+
+// Reads the length of a list or array.
+func (d *decodeState) readLen() int {
+	var length int32
+	if d.Dialect == BedrockNetwork {
+		length = int32(unzigzag(d.readUvarint(5)))
+	} else {
+		length = int32(d.readU32())
+	}
+	if length < 0 {
+		panic(fmt.Errorf("nbt: Negative length %d", length))
+	}
+	return int(length)
+}
+
+// Reads the length of a string.
+func (d *decodeState) readStringLen() int {
+	if d.Dialect == BedrockNetwork {
+		length := d.readUvarint(5)
+		if length > maxStringLength {
+			panic(fmt.Errorf("nbt: String is %d bytes long, which is more than %d", length, maxStringLength))
+		}
+		return int(length)
+	}
+	return int(d.readU16())
+}
+
+// Reads an unsigned varint of at most max bytes.
+func (d *decodeState) readUvarint(max int) uint64 {
+	var v uint64
+	for i := 0; i < max; i++ {
+		b := d.readU8()
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v
+		}
+	}
+	panic(fmt.Errorf("nbt: Varint is more than %d bytes long", max))
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// Returns the next n bytes of input as a slice the caller may keep.
+func (d *decodeState) readBytes(n int) []byte {
+	b := d.next(n)
+	if d.in == nil && d.NoCopy {
+		return b
+	}
+	if d.in != nil && n > len(d.scratch) {
+		return b // Already a fresh allocation.
+	}
+	return append([]byte(nil), b...)
 }
 
 // Returns the name of the tag that was read.
 func (d *decodeState) readTag() (string, Tag) {
-	var tag Tag
-	d.r(&tag)
+	tag := Tag(d.readU8())
 
-	if tag == tagEnd {
+	if tag == TagEnd {
 		return "", tag
 	}
 
@@ -82,42 +281,43 @@ func (d *decodeState) readTag() (string, Tag) {
 
 func (d *decodeState) allocate(tag Tag) reflect.Value {
 	switch tag {
-	case tagByte:
+	case TagByte:
 		return reflect.ValueOf(new(int8)).Elem()
-	case tagShort:
+	case TagShort:
 		return reflect.ValueOf(new(int16)).Elem()
-	case tagInt:
+	case TagInt:
 		return reflect.ValueOf(new(int32)).Elem()
-	case tagLong:
+	case TagLong:
 		return reflect.ValueOf(new(int64)).Elem()
-	case tagFloat:
+	case TagFloat:
 		return reflect.ValueOf(new(float32)).Elem()
-	case tagDouble:
+	case TagDouble:
 		return reflect.ValueOf(new(float64)).Elem()
-	case tagByteArray:
+	case TagByteArray:
 		return reflect.ValueOf(new([]byte)).Elem()
-	case tagString:
+	case TagString:
 		return reflect.ValueOf(new(string)).Elem()
-	case tagList:
+	case TagList:
 		return reflect.ValueOf(new([]interface{})).Elem()
-	case tagCompound:
+	case TagCompound:
 		return reflect.ValueOf(new(map[string]interface{})).Elem()
-	case tagIntArray:
+	case TagIntArray:
 		return reflect.ValueOf(new([]int32)).Elem()
-	case tagLongArray:
+	case TagLongArray:
 		return reflect.ValueOf(new([]int64)).Elem()
 	}
 	panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
 }
 
 func (d *decodeState) readString() string {
-	var length uint16
-	d.r(&length)
+	length := d.readStringLen()
 
-	value := make([]byte, length)
-	_, err := d.in.Read(value)
-	if err != nil {
-		panic(err)
+	value := d.next(length)
+	if d.Dialect == Java && !utf8.Valid(value) {
+		return decodeMUTF8(value)
+	}
+	if d.in == nil && d.NoCopy && length != 0 {
+		return unsafe.String(&value[0], length)
 	}
 
 	return string(value)
@@ -126,19 +326,70 @@ func (d *decodeState) readString() string {
 func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 	switch v.Kind() {
 	case reflect.Int, reflect.Uint:
-		panic(fmt.Errorf("nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."))
+		if !d.ConvertNumbers {
+			panic(fmt.Errorf("nbt: int and uint types are not supported for portability reasons. Try int32 or uint32."))
+		}
 	case reflect.Interface:
-		v.Set(d.allocate(tag))
-		v = v.Elem()
+		if tag == TagCompound && d.Registry != nil {
+			d.readRegistered(v)
+			return
+		}
+		value := d.allocate(tag)
+		if !value.Type().AssignableTo(v.Type()) {
+			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Type()))
+		}
+		d.readValue(tag, value)
+		v.Set(value)
+		return
 	case reflect.Ptr:
 		v.Set(reflect.New(v.Type().Elem()))
 		v = v.Elem()
 	}
 
+	switch v.Type() {
+	case rawTagType:
+		v.Set(reflect.ValueOf(d.readRaw(tag)))
+		return
+	case timeType:
+		if tag != TagString {
+			v.Set(reflect.ValueOf(time.UnixMilli(d.readInt(tag, v))))
+			return
+		}
+	case uuidType:
+		if tag == TagIntArray {
+			if length := d.readLen(); length != 4 {
+				panic(fmt.Errorf("nbt: UUID must be 4 ints long, but it is %d", length))
+			}
+			id := v.Addr().Interface().(*UUID)
+			for i := 0; i < len(id); i += 4 {
+				binary.BigEndian.PutUint32(id[i:], d.readInt32())
+			}
+			return
+		}
+	}
+
+	switch {
+	case tag == TagCompound && implements(v.Type(), unmarshalerType):
+		d.readUnmarshaler(asInterface(v, unmarshalerType).(Unmarshaler))
+		return
+	case tag == TagString && implements(v.Type(), textUnmarshalerType):
+		err := asInterface(v, textUnmarshalerType).(encoding.TextUnmarshaler).UnmarshalText([]byte(d.readString()))
+		if err != nil {
+			panic(err)
+		}
+		return
+	case tag == TagByteArray && implements(v.Type(), binaryUnmarshalerType):
+		data := d.readBytes(d.readLen())
+		err := asInterface(v, binaryUnmarshalerType).(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	switch tag {
-	case tagByte:
-		var value uint8
-		d.r(&value)
+	case TagByte:
+		value := d.readU8()
 		switch v.Kind() {
 		case reflect.Bool:
 			v.SetBool(value != 0)
@@ -147,71 +398,79 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 		case reflect.Uint8:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertInt(tag, int64(int8(value)), v)
 		}
 
-	case tagShort:
-		var value uint16
-		d.r(&value)
+	case TagShort:
+		value := d.readU16()
 		switch v.Kind() {
 		case reflect.Int16:
 			v.SetInt(int64(int16(value)))
 		case reflect.Uint16:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertInt(tag, int64(int16(value)), v)
 		}
 
-	case tagInt:
-		var value uint32
-		d.r(&value)
+	case TagInt:
+		value := d.readInt32()
 		switch v.Kind() {
 		case reflect.Int32:
-			v.SetInt(int64(int16(value)))
+			v.SetInt(int64(int32(value)))
 		case reflect.Uint32:
 			v.SetUint(uint64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertInt(tag, int64(int32(value)), v)
 		}
 
-	case tagLong:
-		var value uint64
-		d.r(&value)
+	case TagLong:
+		value := d.readInt64()
 		switch v.Kind() {
 		case reflect.Int64:
 			v.SetInt(int64(value))
 		case reflect.Uint64:
 			v.SetUint(value)
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertInt(tag, int64(value), v)
 		}
 
-	case tagFloat:
-		var value float32
-		d.r(&value)
+	case TagFloat:
+		value := math.Float32frombits(d.readU32())
 		switch v.Kind() {
 		case reflect.Float32:
 			v.SetFloat(float64(value))
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertFloat(tag, float64(value), v)
 		}
 
-	case tagDouble:
-		var value float64
-		d.r(&value)
+	case TagDouble:
+		value := math.Float64frombits(d.readU64())
 		switch v.Kind() {
 		case reflect.Float64:
 			v.SetFloat(value)
 		default:
-			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+			d.convertFloat(tag, value, v)
 		}
 
-	case tagByteArray:
-		var length uint32
-		d.r(&length)
+	case TagByteArray:
+		length := uint32(d.readLen())
 
 		switch v.Kind() {
 		case reflect.Array, reflect.Slice:
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				if v.Kind() == reflect.Array {
+					if uint32(v.Len()) < length {
+						panic(fmt.Errorf("nbt: Byte array is of length %d, but only the array given is only %d long!", length, v.Len()))
+					}
+					for i, b := range d.next(int(length)) {
+						v.Index(i).SetUint(uint64(b))
+					}
+				} else {
+					v.SetBytes(d.readBytes(int(length)))
+				}
+				break
+			}
+
 			if v.Kind() == reflect.Array {
 				if uint32(v.Len()) < length {
 					panic(fmt.Errorf("nbt: Byte array is of length %d, but only the array given is only %d long!", length, v.Len()))
@@ -224,14 +483,14 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 
 			for i := 0; i < int(length); i++ {
 				value := v.Index(i)
-				d.readValue(tagByte, value)
+				d.readValue(TagByte, value)
 			}
 
 		default:
 			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
 
-	case tagString:
+	case TagString:
 		switch v.Kind() {
 		case reflect.String:
 			v.SetString(d.readString())
@@ -239,11 +498,9 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
 
-	case tagList:
-		var inner Tag
-		d.r(&inner)
-		var length uint32
-		d.r(&length)
+	case TagList:
+		inner := Tag(d.readU8())
+		length := uint32(d.readLen())
 
 		switch v.Kind() {
 		case reflect.Slice:
@@ -257,7 +514,7 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 			var i uint32
 			defer func() {
 				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
+					panic(annotate(r, "\n\t\tat list index %d", i))
 				}
 			}()
 
@@ -267,11 +524,7 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 					value = reflect.New(kind.Elem())
 					d.readValue(inner, value.Elem())
 				} else {
-					if kind.Kind() == reflect.Interface {
-						value = d.allocate(inner)
-					} else {
-						value = reflect.New(kind).Elem()
-					}
+					value = reflect.New(kind).Elem()
 					d.readValue(inner, value)
 				}
 				v.Set(reflect.Append(v, value))
@@ -281,26 +534,43 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
 
-	case tagCompound:
+	case TagCompound:
 		switch v.Kind() {
 		case reflect.Struct:
-			fields := parseStruct(v)
+			fields := cachedFields(v.Type())
+			key, hasKey := d.discriminator(v.Type())
 
 			var name string
 			defer func() {
 				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
+					panic(annotate(r, "\n\t\tat struct field %#v", name))
 				}
 			}()
 
 			for {
 				var tag Tag
 				name, tag = d.readTag()
-				if tag == tagEnd {
+				if tag == TagEnd {
 					break
 				}
-				if field, ok := fields[name]; ok {
-					d.readValue(tag, field)
+				if f, ok := fields.named(name); ok {
+					d.readField(tag, v.Field(f.index), f.opts)
+				} else if hasKey && name == key && tag == TagString {
+					d.readString()
+				} else if f, low, ok := fields.uuidHalf(name); ok && tag == TagLong {
+					field := v.Field(f.index)
+					if field.Kind() == reflect.Ptr {
+						if field.IsNil() {
+							field.Set(reflect.New(uuidType))
+						}
+						field = field.Elem()
+					}
+					id := field.Addr().Interface().(*UUID)
+					if low {
+						binary.BigEndian.PutUint64(id[8:], d.readInt64())
+					} else {
+						binary.BigEndian.PutUint64(id[:8], d.readInt64())
+					}
 				} else {
 					panic(fmt.Errorf("nbt: Unhandled %s", tag))
 				}
@@ -308,34 +578,34 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 
 		case reflect.Map:
 			if v.IsNil() {
-				v.Set(reflect.ValueOf(make(map[string]interface{})))
+				v.Set(reflect.MakeMap(v.Type()))
 			}
+			keyType, elemType := v.Type().Key(), v.Type().Elem()
 
 			var name string
 			defer func() {
 				if r := recover(); r != nil {
-					panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
+					panic(annotate(r, "\n\t\tat struct field %#v", name))
 				}
 			}()
 
 			for {
 				var tag Tag
 				name, tag = d.readTag()
-				if tag == tagEnd {
+				if tag == TagEnd {
 					break
 				}
-				val := d.allocate(tag)
+				val := reflect.New(elemType).Elem()
 				d.readValue(tag, val)
-				v.SetMapIndex(reflect.ValueOf(name), val)
+				v.SetMapIndex(mapKey(keyType, name), val)
 			}
 
 		default:
 			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
 
-	case tagIntArray:
-		var length uint32
-		d.r(&length)
+	case TagIntArray:
+		length := uint32(d.readLen())
 
 		switch v.Kind() {
 		case reflect.Array, reflect.Slice:
@@ -351,15 +621,14 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 
 			for i := 0; i < int(length); i++ {
 				value := v.Index(i)
-				d.readValue(tagInt, value)
+				d.readValue(TagInt, value)
 			}
 
 		default:
 			panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
 		}
-	case tagLongArray:
-		var length uint32
-		d.r(&length)
+	case TagLongArray:
+		length := uint32(d.readLen())
 
 		switch v.Kind() {
 		case reflect.Array, reflect.Slice:
@@ -375,7 +644,7 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 
 			for i := 0; i < int(length); i++ {
 				value := v.Index(i)
-				d.readValue(tagLong, value)
+				d.readValue(TagLong, value)
 			}
 
 		default:
@@ -385,3 +654,116 @@ func (d *decodeState) readValue(tag Tag, v reflect.Value) {
 		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
 	}
 }
+
+// Decodes a struct field, applying its options.
+func (d *decodeState) readField(tag Tag, v reflect.Value, opts tagOptions) {
+	if unit := durationUnit(opts); unit != 0 && v.Type() == durationType {
+		v.SetInt(int64(time.Duration(d.readInt(tag, v)) * unit))
+		return
+	}
+	d.readValue(tag, v)
+}
+
+// Reads the payload of any integer tag, for a value that is stored as an
+// integer but not decoded into one.
+func (d *decodeState) readInt(tag Tag, v reflect.Value) int64 {
+	switch tag {
+	case TagByte:
+		return int64(int8(d.readU8()))
+	case TagShort:
+		return int64(int16(d.readU16()))
+	case TagInt:
+		return int64(int32(d.readInt32()))
+	case TagLong:
+		return int64(d.readInt64())
+	}
+	panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Type()))
+}
+
+var (
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// Turns a compound entry's name into a key for a map with the given key type.
+func mapKey(t reflect.Type, name string) reflect.Value {
+	if implements(t, textUnmarshalerType) {
+		key := reflect.New(t)
+		err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name))
+		if err != nil {
+			panic(err)
+		}
+		return key.Elem()
+	}
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(name).Convert(t)
+	}
+	panic(fmt.Errorf("nbt: Unhandled map key type: %v", t))
+}
+
+// A ConversionError reports a number that can't be decoded into a Go value
+// without losing information.
+type ConversionError struct {
+	Tag   Tag
+	Value interface{} // int64 for integer tags; float64 otherwise.
+	Type  reflect.Type
+}
+
+func (err *ConversionError) Error() string {
+	return fmt.Sprintf("nbt: %s value %v does not fit in a %s", err.Tag, err.Value, err.Type)
+}
+
+// Puts an integer into a value of a kind that doesn't match the tag's width.
+// Unsigned kinds only read the payload's bits as unsigned when the widths
+// match, so a negative number is an error in any other unsigned kind.
+func (d *decodeState) convertInt(tag Tag, value int64, v reflect.Value) {
+	if !d.ConvertNumbers {
+		panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(value != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(value) {
+			panic(&ConversionError{tag, value, v.Type()})
+		}
+		v.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value < 0 || v.OverflowUint(uint64(value)) {
+			panic(&ConversionError{tag, value, v.Type()})
+		}
+		v.SetUint(uint64(value))
+	case reflect.Float32:
+		if int64(float32(value)) != value {
+			panic(&ConversionError{tag, value, v.Type()})
+		}
+		v.SetFloat(float64(value))
+	case reflect.Float64:
+		if int64(float64(value)) != value {
+			panic(&ConversionError{tag, value, v.Type()})
+		}
+		v.SetFloat(float64(value))
+	default:
+		panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	}
+}
+
+// Puts a floating point number into a value of the other float type.
+func (d *decodeState) convertFloat(tag Tag, value float64, v reflect.Value) {
+	if !d.ConvertNumbers {
+		panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	}
+
+	switch v.Kind() {
+	case reflect.Float32:
+		if float64(float32(value)) != value && !math.IsNaN(value) {
+			panic(&ConversionError{tag, value, v.Type()})
+		}
+		v.SetFloat(value)
+	case reflect.Float64:
+		v.SetFloat(value)
+	default:
+		panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %s!", tag, v.Kind()))
+	}
+}
@@ -1,9 +1,17 @@
 package nbt
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"unsafe"
 )
 
 type ServerList struct {
@@ -126,7 +134,7 @@ func TestErrMissingField(t *testing.T) {
 	err = Unmarshal(Uncompressed, f, &list)
 	if err == nil {
 		t.Error("No error, but one was expected!")
-	} else if err.Error() != "nbt: Unhandled tagList (0x09)\n\t\tat struct field \"servers\"" {
+	} else if err.Error() != "nbt: Unhandled TAG_List (0x09)\n\t\tat struct field \"servers\"" {
 		t.Error(err)
 	}
 }
@@ -152,7 +160,7 @@ func TestErrWrongType(t *testing.T) {
 	err = Unmarshal(Uncompressed, f, &list)
 	if err == nil {
 		t.Error("No error, but one was expected!")
-	} else if err.Error() != "nbt: Tag is tagString (0x08), but I don't know how to put that in a float64!\n\t\tat struct field \"ip\"\n\t\tat list index 0\n\t\tat struct field \"servers\"" {
+	} else if err.Error() != "nbt: Tag is TAG_String (0x08), but I don't know how to put that in a float64!\n\t\tat struct field \"ip\"\n\t\tat list index 0\n\t\tat struct field \"servers\"" {
 		t.Error(err)
 	}
 }
@@ -196,7 +204,7 @@ func TestBigTest(t *testing.T) {
 	expected := BigTest{
 		ByteTest:   127,
 		ShortTest:  32767,
-		IntTest:    -1,
+		IntTest:    2147483647,
 		LongTest:   9223372036854775807,
 		FloatTest:  0.49823147,
 		DoubleTest: 0.4931287132182315,
@@ -311,3 +319,689 @@ func TestBigTest(t *testing.T) {
 		}
 	}
 }
+
+func TestUnmarshalBytes(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fromReader BigTest
+	err = Unmarshal(Uncompressed, bytes.NewReader(data), &fromReader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Trailing bytes belong to the next packet and must not be consumed.
+	packet := append(append([]byte(nil), data...), 0xde, 0xad)
+
+	for _, noCopy := range []bool{false, true} {
+		var bigTest BigTest
+		n, err := (&Decoder{NoCopy: noCopy}).UnmarshalBytes(packet, &bigTest)
+		if err != nil {
+			t.Error(err)
+		}
+		if n != len(data) {
+			t.Errorf("NoCopy=%v: consumed %d bytes, but expected %d.", noCopy, n, len(data))
+		}
+		if !reflect.DeepEqual(bigTest, fromReader) {
+			t.Errorf("NoCopy=%v: UnmarshalBytes and Unmarshal disagree.", noCopy)
+		}
+
+		start := uintptr(unsafe.Pointer(&packet[0]))
+		p := uintptr(unsafe.Pointer(&bigTest.ByteArray[0]))
+		aliased := p >= start && p < start+uintptr(len(packet))
+		if aliased != noCopy {
+			t.Errorf("NoCopy=%v: byte array aliases input: %v", noCopy, aliased)
+		}
+	}
+
+	_, err = UnmarshalBytes(data[:len(data)/2], new(BigTest))
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+}
+
+type BlockID string
+
+// Namespaced resource location; "stone" and "minecraft:stone" are the same.
+type ResourceLocation struct {
+	Namespace, Path string
+}
+
+func (r ResourceLocation) MarshalText() ([]byte, error) {
+	return []byte(r.Namespace + ":" + r.Path), nil
+}
+
+func (r *ResourceLocation) UnmarshalText(text []byte) error {
+	r.Namespace, r.Path = "minecraft", string(text)
+	if i := strings.IndexByte(r.Path, ':'); i >= 0 {
+		r.Namespace, r.Path = r.Path[:i], r.Path[i+1:]
+	}
+	return nil
+}
+
+func TestTypedMaps(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var all map[string]interface{}
+	err = Unmarshal(GZip, f, &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := AppendMarshal(nil, map[string]interface{}{"nested compound test": all["nested compound test"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bigTest struct {
+		Nested map[string]Food `nbt:"nested compound test"`
+	}
+	_, err = UnmarshalBytes(data, &bigTest)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := map[string]Food{"ham": {Name: "Hampus", Value: 0.75}, "egg": {Name: "Eggbert", Value: 0.5}}
+	if !reflect.DeepEqual(bigTest.Nested, expected) {
+		t.Errorf("Decoded %#v, but expected %#v", bigTest.Nested, expected)
+	}
+
+	counts := map[BlockID]int32{"minecraft:stone": 64, "minecraft:dirt": 3}
+	data, err = AppendMarshal(nil, counts)
+	if err != nil {
+		t.Error(err)
+	}
+	var decodedCounts map[BlockID]int32
+	_, err = UnmarshalBytes(data, &decodedCounts)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(decodedCounts, counts) {
+		t.Errorf("Decoded %#v, but expected %#v", decodedCounts, counts)
+	}
+
+	var locations map[ResourceLocation]int32
+	_, err = UnmarshalBytes(data, &locations)
+	if err != nil {
+		t.Error(err)
+	}
+	if locations[ResourceLocation{"minecraft", "stone"}] != 64 {
+		t.Errorf("Decoded %#v", locations)
+	}
+	data, err = AppendMarshal(nil, map[ResourceLocation]int32{{"minecraft", "stone"}: 64})
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Contains(data, []byte("minecraft:stone")) {
+		t.Errorf("Encoded % x, which does not contain the marshaled key", data)
+	}
+}
+
+func TestConvertNumbers(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Widened struct {
+		ByteTest     int           `nbt:"byteTest"`
+		ShortTest    float32       `nbt:"shortTest"`
+		IntTest      int64         `nbt:"intTest"`
+		LongTest     uint64        `nbt:"longTest"`
+		FloatTest    float64       `nbt:"floatTest"`
+		DoubleTest   float64       `nbt:"doubleTest"`
+		StringTest   string        `nbt:"stringTest"`
+		Nested       interface{}   `nbt:"nested compound test"`
+		LongList     []interface{} `nbt:"listTest (long)"`
+		CompoundList []struct {
+			Name    string `nbt:"name"`
+			Created int64  `nbt:"created-on"`
+		} `nbt:"listTest (compound)"`
+		ByteArray []uint32 `nbt:"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"`
+	}
+
+	_, err = UnmarshalBytes(data, new(Widened))
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+
+	var widened Widened
+	dec := Decoder{ConvertNumbers: true}
+	_, err = dec.UnmarshalBytes(data, &widened)
+	if err != nil {
+		t.Error(err)
+	}
+	if widened.ByteTest != 127 || widened.ShortTest != 32767 || widened.IntTest != 2147483647 ||
+		widened.LongTest != 9223372036854775807 || widened.FloatTest != float64(float32(0.49823147)) {
+		t.Errorf("Decoded %+v", widened)
+	}
+	if len(widened.ByteArray) != 1000 || widened.ByteArray[1] != 62 {
+		t.Errorf("Decoded byte array %v", widened.ByteArray)
+	}
+
+	data, err = AppendMarshal(nil, map[string]interface{}{"Count": int16(300)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var narrowed struct {
+		Count int8
+	}
+	_, err = dec.UnmarshalBytes(data, &narrowed)
+	var conversionErr *ConversionError
+	if !errors.As(err, &conversionErr) {
+		t.Fatalf("Expected a *ConversionError, but got %v", err)
+	}
+	if conversionErr.Tag != TagShort || conversionErr.Value != int64(300) || conversionErr.Type.Kind() != reflect.Int8 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if narrowed.Count != 0 {
+		t.Errorf("Count was set to %d", narrowed.Count)
+	}
+
+	// Unsigned values read the payload's bits only at the tag's own width;
+	// wider, a negative number doesn't fit.
+	data, err = AppendMarshal(nil, map[string]interface{}{"Count": uint32(3000000000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsigned struct {
+		Count uint32
+	}
+	if _, err = dec.UnmarshalBytes(data, &unsigned); err != nil || unsigned.Count != 3000000000 {
+		t.Errorf("Decoded %+v, %v", unsigned, err)
+	}
+	data, err = AppendMarshal(nil, map[string]interface{}{"Delta": int32(-5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wide struct {
+		Delta uint64
+	}
+	_, err = dec.UnmarshalBytes(data, &wide)
+	if !errors.As(err, &conversionErr) || conversionErr.Value != int64(-5) || conversionErr.Type.Kind() != reflect.Uint64 {
+		t.Errorf("Expected a *ConversionError for -5, but got %v", err)
+	}
+	if wide.Delta != 0 {
+		t.Errorf("Delta was set to %d", wide.Delta)
+	}
+	var small struct {
+		Count uint
+	}
+	data, err = AppendMarshal(nil, map[string]interface{}{"Count": int8(-1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dec.UnmarshalBytes(data, &small); !errors.As(err, &conversionErr) {
+		t.Errorf("Expected a *ConversionError for -1b, but got %v", err)
+	}
+}
+
+func TestRawTag(t *testing.T) {
+	f, err := os.Open("testcases/servers.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var list struct {
+		Servers []map[string]RawTag `nbt:"servers"`
+	}
+	err = Unmarshal(Uncompressed, f, &list)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(list.Servers) != 3 {
+		t.Fatalf("Server list length is %d, but expected 3.", len(list.Servers))
+	}
+
+	var name string
+	err = list.Servers[2]["name"].Unmarshal(&name)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Servers[2].Name", name, "☃")
+
+	// Re-encoding copies the raw bytes, so the result decodes like the original.
+	data, err := AppendMarshal(nil, list)
+	if err != nil {
+		t.Error(err)
+	}
+	var servers ServerList
+	_, err = UnmarshalBytes(data, &servers)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Servers[1].IP", servers.Servers[1].IP, "when:12345")
+
+	var whole RawTag
+	n, err := UnmarshalBytes(data, &whole)
+	if err != nil {
+		t.Error(err)
+	}
+	if whole.Tag != TagCompound || len(whole.Payload) != n-3 {
+		t.Errorf("Decoded %s with %d bytes of payload out of %d", whole.Tag, len(whole.Payload), n)
+	}
+	servers = ServerList{}
+	err = whole.Unmarshal(&servers)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Servers[0].Name", servers.Servers[0].Name, "Who")
+
+	// The zero RawTag has no type, so writing it would end the compound early.
+	_, err = AppendMarshal(nil, map[string]RawTag{"a": {}, "b": whole})
+	if err == nil {
+		t.Error("Marshaled an empty RawTag")
+	}
+
+	// Skipping a payload on a stream doesn't trust its length up front.
+	huge := []byte{0x0a, 0, 0, 0x0b, 0, 1, 'a', 0x7f, 0xff, 0xff, 0xff, 1, 2, 3, 4}
+	err = Unmarshal(Uncompressed, bytes.NewReader(huge), &whole)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Skipping a truncated int array gave %v", err)
+	}
+}
+
+type BlockEntity interface {
+	Position() (x, y, z int32)
+}
+
+type Chest struct {
+	X, Y, Z int32 `nbt:"-"`
+	Items   []InventoryItem
+}
+
+func (c *Chest) Position() (int32, int32, int32) { return c.X, c.Y, c.Z }
+
+type Sign struct {
+	Text string
+}
+
+func (s Sign) Position() (int32, int32, int32) { return 0, 0, 0 }
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry("id")
+	registry.Register("minecraft:chest", (*Chest)(nil))
+	registry.Register("minecraft:sign", Sign{})
+
+	type Chunk struct {
+		BlockEntities []BlockEntity
+		Extra         interface{}
+	}
+	chunk := Chunk{
+		BlockEntities: []BlockEntity{
+			&Chest{Items: []InventoryItem{{Type: 1, Count: 64}}},
+			Sign{Text: "Hello"},
+		},
+		Extra: map[string]interface{}{"id": "minecraft:unknown"},
+	}
+
+	data, err := (&Encoder{Registry: registry}).AppendMarshal(nil, chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var generic struct {
+		BlockEntities []map[string]interface{}
+		Extra         map[string]interface{}
+	}
+	_, err = UnmarshalBytes(data, &generic)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "BlockEntities[0].id", generic.BlockEntities[0]["id"].(string), "minecraft:chest")
+	assertString(t, "BlockEntities[1].id", generic.BlockEntities[1]["id"].(string), "minecraft:sign")
+
+	var result Chunk
+	_, err = (&Decoder{Registry: registry}).UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, chunk) {
+		t.Errorf("Decoded %#v", result)
+		t.Logf("Expected %#v", chunk)
+	}
+
+	_, err = UnmarshalBytes(data, &result)
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+
+	// The same from a stream, which can't be looked at twice.
+	result = Chunk{}
+	err = (&Decoder{Registry: registry}).Unmarshal(Uncompressed, bytes.NewReader(data), &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, chunk) {
+		t.Errorf("Decoded %#v from a stream", result)
+	}
+
+	// Only registered types have a discriminator to skip.
+	data, err = AppendMarshal(nil, map[string]interface{}{"Text": "Hi", "": "?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain struct{ Text string }
+	_, err = (&Decoder{Registry: registry}).UnmarshalBytes(data, &plain)
+	if err == nil {
+		t.Error("No error for an entry named \"\"")
+	}
+}
+
+func TestParseSNBT(t *testing.T) {
+	for in, expected := range map[string]string{
+		`{Slot:3b,id:"minecraft:stone",Count:1b}`: `map[string]interface {}{"Count":1, "Slot":3, "id":"minecraft:stone"}`,
+		`[1.5f, 2.5f]`:                        `[]interface {}{1.5, 2.5}`,
+		`[I; 1, 2, -3]`:                       `[]int32{1, 2, -3}`,
+		`[B;]`:                                `[]byte{}`,
+		`{'quoted "key"': 1.0, x: 2d, y: 3L}`: `map[string]interface {}{"quoted \"key\"":1, "x":2, "y":3}`,
+		`{a: true, b: 300b, c: 1e3}`:          `map[string]interface {}{"a":1, "b":"300b", "c":"1e3"}`,
+	} {
+		v, err := ParseSNBT(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		assertString(t, in, fmt.Sprintf("%#v", v), expected)
+	}
+
+	for _, in := range []string{`{a:1`, `[1, 2b]`, `[I; 1b]`, `{a:1} x`, `"unterminated`} {
+		if _, err := ParseSNBT(in); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestPathQuery(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree interface{}
+	err = Unmarshal(GZip, bytes.NewReader(data), &tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for query, expected := range map[string]string{
+		`"nested compound test".ham.name`:                    `"nested compound test".ham.name=Hampus`,
+		`"listTest (long)"[0]`:                               `"listTest (long)"[0]=11`,
+		`"listTest (long)"[-1]`:                              `"listTest (long)"[4]=15`,
+		`"listTest (compound)"[].name`:                       `"listTest (compound)"[0].name=Compound tag #0; "listTest (compound)"[1].name=Compound tag #1`,
+		`"listTest (compound)"[{name:"Compound tag #1"}]`:    `"listTest (compound)"[1]=map[created-on:1264099775885 name:Compound tag #1]`,
+		`"nested compound test"{egg:{value:0.5f}}.egg.name`:  `"nested compound test".egg.name=Eggbert`,
+		`"nested compound test"{egg:{value:0.25f}}.egg.name`: ``,
+		`{shortTest:32767s}.intTest`:                         `intTest=2147483647`,
+		`{shortTest:32767}.intTest`:                          ``,
+		`"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"[1]`: `"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"[1]=62`,
+		`missing[0].x`: ``,
+		`byteTest.x`:   ``,
+	} {
+		path, err := ParsePath(query)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+			continue
+		}
+		assertString(t, query, path.String(), query)
+
+		assertString(t, query, formatMatches(path.Find(tree)), expected)
+
+		matches, err := Query(GZip, bytes.NewReader(data), path)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+		assertString(t, query+" (stream)", formatMatches(matches), expected)
+	}
+
+	for _, query := range []string{``, `a.`, `a..b`, `a[x]`, `a[0`, `a b`} {
+		if _, err := ParsePath(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func formatMatches(matches []Match) string {
+	s := make([]string, len(matches))
+	for i, m := range matches {
+		s[i] = fmt.Sprintf("%s=%v", m.Path, m.Value)
+	}
+	return strings.Join(s, "; ")
+}
+
+func TestPatch(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(query string, v interface{}, count int) []byte {
+		path, err := ParsePath(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, n, err := PatchBytes(data, path, v)
+		if err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+		if n != count {
+			t.Errorf("%s: replaced %d tags, but expected %d", query, n, count)
+		}
+		return patched
+	}
+
+	if patched := patch(`missing.x`, int8(1), 0); !bytes.Equal(patched, data) {
+		t.Error("Patching nothing changed the document")
+	}
+	if patched := patch(`intTest`, int32(5), 1); len(patched) != len(data) || bytes.Equal(patched, data) {
+		t.Error("Patching a TAG_Int didn't replace it in place")
+	}
+
+	for _, test := range []struct {
+		query    string
+		v        interface{}
+		check    string
+		expected string
+	}{
+		{`intTest`, int32(-1), `intTest`, `intTest=-1`},
+		{`shortTest`, "no longer a short", `shortTest`, `shortTest=no longer a short`},
+		{`"nested compound test".egg.name`, "Eggbert the Second", `"nested compound test"`, `"nested compound test"=map[egg:map[name:Eggbert the Second value:0.5] ham:map[name:Hampus value:0.75]]`},
+		{`"listTest (long)"[-1]`, int64(99), `"listTest (long)"`, `"listTest (long)"=[11 12 13 14 99]`},
+		{`"listTest (compound)"[{name:"Compound tag #1"}]`, map[string]interface{}{"name": "replaced"}, `"listTest (compound)"[].name`, `"listTest (compound)"[0].name=Compound tag #0; "listTest (compound)"[1].name=replaced`},
+		{`"nested compound test"{ham:{name:"Hampus"}}.ham`, Food{Name: "Ham", Value: 2}, `"nested compound test".ham`, `"nested compound test".ham=map[name:Ham value:2]`},
+		{`"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"[1]`, int8(-1), `"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"[1]`, `"byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"[1]=-1`},
+	} {
+		var tree interface{}
+		_, err := UnmarshalBytes(patch(test.query, test.v, 1), &tree)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		check, _ := ParsePath(test.check)
+		assertString(t, test.query, formatMatches(check.Find(tree)), test.expected)
+		assertString(t, test.query, fmt.Sprint(tree.(map[string]interface{})["stringTest"]), "HELLO WORLD THIS IS A TEST STRING ÅÄÖ!")
+	}
+
+	path, _ := ParsePath(`"listTest (long)"[0]`)
+	_, _, err = PatchBytes(data, path, "eleven")
+	if err == nil || !strings.Contains(err.Error(), `"listTest (long)"[0]`) {
+		t.Errorf("Replacing a list element with a different tag: %v", err)
+	}
+
+	// Documents bigger than the output buffer are written out in pieces.
+	big := map[string]interface{}{"list": make([]interface{}, 20000)}
+	for i := range big["list"].([]interface{}) {
+		big["list"].([]interface{})[i] = map[string]interface{}{"i": int32(i), "s": "some text"}
+	}
+	data, err = AppendMarshal(nil, big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := patch(`list[{i:19999}].s`, "patched", 1)
+	var tree interface{}
+	_, err = UnmarshalBytes(patched, &tree)
+	if err != nil {
+		t.Error(err)
+	}
+	path, _ = ParsePath(`list[].s`)
+	matches := path.Find(tree)
+	if len(matches) != 20000 || matches[19999].Value != "patched" || matches[19998].Value != "some text" {
+		t.Errorf("Patched the wrong tags in a big document: %d matches", len(matches))
+	}
+
+	// Compressed streams are recompressed.
+	var out bytes.Buffer
+	f.Seek(0, 0)
+	path, _ = ParsePath(`byteTest`)
+	_, err = Patch(GZip, f, &out, path, int8(-5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bigTest BigTest
+	err = Unmarshal(GZip, &out, &bigTest)
+	if err != nil {
+		t.Error(err)
+	}
+	if bigTest.ByteTest != -5 || bigTest.Nested.Egg.Name != "Eggbert" {
+		t.Errorf("Patched byteTest to %d and nested egg name to %q", bigTest.ByteTest, bigTest.Nested.Egg.Name)
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	for file, expected := range map[string]Compression{
+		"testcases/bigtest.nbt":      GZip,
+		"testcases/Nightgunner5.dat": GZip,
+		"testcases/servers.dat":      Uncompressed,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := DetectCompression(data); c != expected {
+			t.Errorf("%s: detected %s, but expected %s", file, c, expected)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Marshal(ZLib, &buf, map[string]interface{}{"a": int8(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := DetectCompression(buf.Bytes()); c != ZLib {
+		t.Errorf("Detected %s, but expected zlib", c)
+	}
+	if c := DetectCompression(nil); c != Uncompressed {
+		t.Errorf("Detected %s for no data", c)
+	}
+
+	if c, err := ParseCompression("GZip"); c != GZip || err != nil {
+		t.Errorf("Parsed GZip as %s, %v", c, err)
+	}
+	if d, err := ParseDialect("bedrock"); d != Bedrock || err != nil {
+		t.Errorf("Parsed bedrock as %s, %v", d, err)
+	}
+	if d, err := ParseDialect("bedrock-network"); d != BedrockNetwork || err != nil {
+		t.Errorf("Parsed bedrock-network as %s, %v", d, err)
+	}
+	if _, err := ParseDialect("pocket"); err == nil {
+		t.Error("No error, but one was expected!")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := InferSchema(GZip, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := schema.Field("listTest (compound)"); !ok || f.Schema.Elem.Tag != TagCompound || len(f.Schema.Elem.Fields) != 2 {
+		t.Errorf("Inferred %#v", f)
+	}
+	if err := Validate(GZip, bytes.NewReader(data), schema); err != nil {
+		t.Error(err)
+	}
+
+	// A field missing from one sample becomes optional, and numbers widen.
+	a, _ := InferSchema(Uncompressed, bytes.NewReader(mustMarshal(t, map[string]interface{}{"n": int8(1), "s": "x"})))
+	b, _ := InferSchema(Uncompressed, bytes.NewReader(mustMarshal(t, map[string]interface{}{"n": int32(1)})))
+	merged := MergeSchemas(a, b)
+	if f, _ := merged.Field("n"); f.Schema.Tag != TagInt || f.Optional {
+		t.Errorf("Merged n into %#v", f)
+	}
+	if f, _ := merged.Field("s"); !f.Optional {
+		t.Errorf("Merged s into %#v", f)
+	}
+
+	schema = &Schema{Tag: TagCompound, Fields: []Field{
+		{Name: "id", Schema: &Schema{Tag: TagString, Enum: []string{"minecraft:pig", "minecraft:cow"}}},
+		{Name: "Health", Schema: &Schema{Tag: TagFloat, Range: &Range{Min: 0, Max: 20}}},
+		{Name: "Age", Schema: &Schema{Tag: TagInt}},
+		{Name: "CustomName", Schema: &Schema{Tag: TagString}, Optional: true},
+		{Name: "Pos", Schema: &Schema{Tag: TagList, Elem: &Schema{Tag: TagDouble}}},
+		{Name: "Tags", Schema: &Schema{Tag: TagList}},
+	}}
+	err = ValidateTree(map[string]interface{}{
+		"id":     "minecraft:sheep",
+		"Health": float32(25),
+		"Age":    int16(3),
+		"Pos":    []interface{}{float64(0), float64(64), "0"},
+		"Tags":   []interface{}{"a"},
+		"Motion": []interface{}{},
+	}, schema)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected a *SchemaError, but got %v", err)
+	}
+	var violations []string
+	for _, v := range schemaErr.Violations {
+		violations = append(violations, v.String())
+	}
+	assertString(t, "Violations", strings.Join(violations, "\n"), `id: "minecraft:sheep" is not one of ["minecraft:pig" "minecraft:cow"]
+Health: 25 is not between 0 and 20
+Pos[2]: expected `+TagDouble.String()+`, but found `+TagString.String()+`
+Motion: not in the schema`)
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := AppendMarshal(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,224 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type ChangeKind byte
+
+const (
+	Added   ChangeKind = iota // The tag is only in the new document.
+	Removed                   // The tag is only in the old document.
+	Changed                   // The tag's value is different.
+	Retyped                   // The tag is a different type of tag.
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	case Retyped:
+		return "retyped"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", byte(kind))
+}
+
+// A Change is one difference between two NBT documents.
+type Change struct {
+	Kind ChangeKind
+	Path string // The path to the tag, e.g. Inventory[2].tag
+
+	// The tag's values in the old and new documents. Old is nil for Added and
+	// New is nil for Removed.
+	Old, New interface{}
+
+	// For changes to a run of array elements, the elements from Start up to
+	// End; Old and New hold just those elements. Otherwise both are 0.
+	Start, End int
+}
+
+// Compares two trees of values like the ones Unmarshal produces when decoding
+// into an interface{}, and returns the differences in the order the tags
+// appear, with compound entries sorted by name. List elements are compared by
+// position; runs of different array elements are reported as one change.
+// Other values are converted as for FormatSNBT.
+func Diff(a, b interface{}) (changes []Change, err error) {
+	defer recoverError(&err)
+	diff(nil, toTree(a), toTree(b), &changes)
+	return
+}
+
+// Decodes two documents and compares them with Diff.
+func DiffStreams(compression Compression, a, b io.Reader) ([]Change, error) {
+	return new(Decoder).DiffStreams(compression, a, b)
+}
+
+func (dec *Decoder) DiffStreams(compression Compression, a, b io.Reader) ([]Change, error) {
+	var treeA, treeB interface{}
+	if err := dec.Unmarshal(compression, a, &treeA); err != nil {
+		return nil, err
+	}
+	if err := dec.Unmarshal(compression, b, &treeB); err != nil {
+		return nil, err
+	}
+	return Diff(treeA, treeB)
+}
+
+func diff(at []pathElem, a, b interface{}, changes *[]Change) {
+	tagA, _ := dynamicTag(a)
+	tagB, _ := dynamicTag(b)
+	if tagA != tagB {
+		*changes = append(*changes, Change{Kind: Retyped, Path: formatPath(at), Old: a, New: b})
+		return
+	}
+
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b := b.(map[string]interface{})
+		names := sortedKeys(a)
+		for name := range b {
+			if _, ok := a[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			elems := append(at, pathElem{name, -1})
+			before, inA := a[name]
+			after, inB := b[name]
+			switch {
+			case !inB:
+				*changes = append(*changes, Change{Kind: Removed, Path: formatPath(elems), Old: before})
+			case !inA:
+				*changes = append(*changes, Change{Kind: Added, Path: formatPath(elems), New: after})
+			default:
+				diff(elems, before, after, changes)
+			}
+		}
+
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) || i < len(b); i++ {
+			elems := append(at, pathElem{index: i})
+			switch {
+			case i >= len(b):
+				*changes = append(*changes, Change{Kind: Removed, Path: formatPath(elems), Old: a[i]})
+			case i >= len(a):
+				*changes = append(*changes, Change{Kind: Added, Path: formatPath(elems), New: b[i]})
+			default:
+				diff(elems, a[i], b[i], changes)
+			}
+		}
+
+	case []byte:
+		y := b.([]byte)
+		diffArrays(formatPath(at), reflect.ValueOf(a), reflect.ValueOf(y), func(i int) bool { return a[i] == y[i] }, changes)
+	case []int32:
+		y := b.([]int32)
+		diffArrays(formatPath(at), reflect.ValueOf(a), reflect.ValueOf(y), func(i int) bool { return a[i] == y[i] }, changes)
+	case []int64:
+		y := b.([]int64)
+		diffArrays(formatPath(at), reflect.ValueOf(a), reflect.ValueOf(y), func(i int) bool { return a[i] == y[i] }, changes)
+
+	case float32:
+		if math.Float32bits(a) != math.Float32bits(b.(float32)) {
+			*changes = append(*changes, Change{Kind: Changed, Path: formatPath(at), Old: a, New: b})
+		}
+	case float64:
+		if math.Float64bits(a) != math.Float64bits(b.(float64)) {
+			*changes = append(*changes, Change{Kind: Changed, Path: formatPath(at), Old: a, New: b})
+		}
+
+	default:
+		if a != b {
+			*changes = append(*changes, Change{Kind: Changed, Path: formatPath(at), Old: a, New: b})
+		}
+	}
+}
+
+// Finds the runs of elements that differ between two arrays of the same type,
+// compared by equal, which is given an index less than both their lengths.
+func diffArrays(path string, a, b reflect.Value, equal func(i int) bool, changes *[]Change) {
+	common := a.Len()
+	if b.Len() < common {
+		common = b.Len()
+	}
+
+	for i := 0; i < common; {
+		if equal(i) {
+			i++
+			continue
+		}
+		start := i
+		for i < common && !equal(i) {
+			i++
+		}
+		*changes = append(*changes, Change{
+			Kind:  Changed,
+			Path:  path,
+			Old:   a.Slice(start, i).Interface(),
+			New:   b.Slice(start, i).Interface(),
+			Start: start,
+			End:   i,
+		})
+	}
+
+	if a.Len() > common {
+		*changes = append(*changes, Change{Kind: Removed, Path: path, Old: a.Slice(common, a.Len()).Interface(), Start: common, End: a.Len()})
+	}
+	if b.Len() > common {
+		*changes = append(*changes, Change{Kind: Added, Path: path, New: b.Slice(common, b.Len()).Interface(), Start: common, End: b.Len()})
+	}
+}
+
+// Formats a change as lines of a unified diff, with values in SNBT, as for an
+// item's Count going from 1b to 2b:
+//
+//	fmt.Println(change)
+//	// Output:
+//	// - Inventory[0].Count: 1b
+//	// + Inventory[0].Count: 2b
+//
+// Runs of array elements are shown with their range after the path, as in
+// Data[4:6]. Values SNBT can't hold, like NaN, are shown as fmt shows them.
+func (change Change) String() string {
+	path := change.Path
+	if change.End > 0 {
+		path = fmt.Sprintf("%s[%d:%d]", path, change.Start, change.End)
+	}
+	var lines []string
+	if change.Kind != Added {
+		lines = append(lines, "- "+path+": "+formatChanged(change.Old))
+	}
+	if change.Kind != Removed {
+		lines = append(lines, "+ "+path+": "+formatChanged(change.New))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatChanged(v interface{}) string {
+	s, err := FormatSNBT(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return s
+}
+
+// Formats a list of changes as a unified diff, one line per old or new value.
+func FormatDiff(changes []Change) string {
+	var b strings.Builder
+	for _, change := range changes {
+		b.WriteString(change.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
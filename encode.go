@@ -3,315 +3,708 @@ package nbt
 import (
 	"compress/gzip"
 	"compress/zlib"
+	"encoding"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
-func Marshal(compression Compression, out io.Writer, v interface{}) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if s, ok := r.(string); ok {
-				err = fmt.Errorf(s)
-			} else {
-				err = r.(error)
-			}
-		}
-	}()
+func Marshal(compression Compression, out io.Writer, v interface{}) error {
+	return defaultEncoder.Marshal(compression, out, v)
+}
+
+// Appends the uncompressed NBT encoding of v to dst and returns the extended
+// buffer.
+func AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	return defaultEncoder.AppendMarshal(dst, v)
+}
+
+var defaultEncoder Encoder
+
+// An Encoder holds options for encoding. The zero value encodes exactly like
+// Marshal and AppendMarshal. An Encoder remembers how large its output tends to
+// be so it can size buffers up front; it is safe for concurrent use but must
+// not be copied after first use.
+type Encoder struct {
+	// The flavour of NBT to write. The default is Java.
+	Dialect Dialect
+
+	// Strings longer than 65535 bytes and lists and arrays with more than
+	// 2147483647 elements can't be represented. Normally they make encoding
+	// fail with a *LengthError, but if Truncate is set they are cut down to
+	// the limit instead and OnTruncate, if not nil, is told about it.
+	Truncate   bool
+	OnTruncate func(*LengthError)
+
+	// If not nil, registered struct types are written with their
+	// discriminator. See Registry.
+	Registry *Registry
+
+	sizeHint atomic.Int64 // A moving average of encoded sizes, for preallocation.
+}
+
+func (enc *Encoder) Marshal(compression Compression, out io.Writer, v interface{}) error {
+	return enc.MarshalNamed(compression, out, "", v)
+}
+
+// Like Marshal, but gives the root tag a name. Minecraft leaves it empty in
+// most files, but some tools and older files use it.
+func MarshalNamed(compression Compression, out io.Writer, name string, v interface{}) error {
+	return defaultEncoder.MarshalNamed(compression, out, name, v)
+}
+
+func (enc *Encoder) MarshalNamed(compression Compression, out io.Writer, name string, v interface{}) (err error) {
+	defer recoverError(&err)
 
 	if out == nil {
 		panic(fmt.Errorf("nbt: Output stream is nil"))
 	}
+	if compression > ZLib {
+		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+	}
+
+	e := enc.newEncodeState()
+	defer e.release()
+	e.marshal(name, v)
+
+	return writeCompressed(compression, out, e.buf)
+}
 
+// Writes an encoded document to out with the given compression.
+func writeCompressed(compression Compression, out io.Writer, b []byte) (err error) {
 	switch compression {
 	case Uncompressed:
-		break
+		_, err = out.Write(b)
+
 	case GZip:
-		w := gzip.NewWriter(out)
-		defer w.Close()
-		out = w
+		z, _ := gzipWriters.Get().(*gzip.Writer)
+		if z == nil {
+			z = gzip.NewWriter(out)
+		} else {
+			z.Reset(out)
+		}
+		err = writeAndClose(z, b)
+		gzipWriters.Put(z)
+
 	case ZLib:
-		w := zlib.NewWriter(out)
-		defer w.Close()
-		out = w
-	default:
-		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+		z, _ := zlibWriters.Get().(*zlib.Writer)
+		if z == nil {
+			z = zlib.NewWriter(out)
+		} else {
+			z.Reset(out)
+		}
+		err = writeAndClose(z, b)
+		zlibWriters.Put(z)
 	}
 
-	writeRootTag(out, reflect.ValueOf(v))
-
 	return
 }
 
-func writeRootTag(out io.Writer, v reflect.Value) {
-	writeTag(out, "", v)
+func (enc *Encoder) AppendMarshal(dst []byte, v interface{}) (b []byte, err error) {
+	b = dst
+	defer recoverError(&err)
+
+	e := encodeState{Encoder: enc, order: enc.Dialect.byteOrder(), buf: dst}
+	if hint := int(enc.sizeHint.Load()); cap(dst)-len(dst) < hint {
+		e.buf = slices.Grow(dst, hint)
+	}
+	e.marshal("", v)
+
+	return e.buf, nil
 }
 
-func w(out io.Writer, v interface{}) {
-	err := binary.Write(out, binary.BigEndian, v)
-	if err != nil {
-		panic(err)
+func writeAndClose(w io.WriteCloser, b []byte) error {
+	_, err := w.Write(b)
+	if cerr := w.Close(); err == nil {
+		err = cerr
 	}
+	return err
 }
 
-func writeTag(out io.Writer, name string, v reflect.Value) {
-	v = reflect.Indirect(v)
-	defer func() {
-		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat struct field %#v", r, name))
+var (
+	encodeStates sync.Pool // *encodeState
+	gzipWriters  sync.Pool // *gzip.Writer
+	zlibWriters  sync.Pool // *zlib.Writer
+)
+
+// Buffers that grew beyond this are left for the garbage collector instead of
+// being pooled, so one huge value doesn't pin its memory forever.
+const maxPooledBuffer = 1 << 20
+
+type encodeState struct {
+	*Encoder
+	order byteOrder
+
+	buf  []byte
+	path []pathElem // Where we are, starting with the root tag.
+}
+
+func (enc *Encoder) newEncodeState() *encodeState {
+	e, _ := encodeStates.Get().(*encodeState)
+	if e == nil {
+		e = new(encodeState)
+	}
+	e.Encoder = enc
+	e.order = enc.Dialect.byteOrder()
+	if hint := int(enc.sizeHint.Load()); cap(e.buf) < hint {
+		e.buf = make([]byte, 0, hint)
+	}
+	return e
+}
+
+func (e *encodeState) release() {
+	if cap(e.buf) > maxPooledBuffer {
+		return
+	}
+	e.Encoder = nil
+	e.buf = e.buf[:0]
+	e.path = e.path[:0]
+	encodeStates.Put(e)
+}
+
+func (e *encodeState) marshal(name string, v interface{}) {
+	start := len(e.buf)
+	e.writeTag(name, reflect.ValueOf(v), nil)
+
+	// Each size moves the hint an eighth of the way towards it, so an odd
+	// huge value only inflates the buffers of the next few encodes, and the
+	// hint never asks for more than a pooled buffer may hold.
+	size := int64(min(len(e.buf)-start, maxPooledBuffer))
+	for {
+		hint := e.sizeHint.Load()
+		if e.sizeHint.CompareAndSwap(hint, hint+(size-hint)/8) {
+			break
 		}
-	}()
-	for v.Kind() == reflect.Interface {
-		v = v.Elem()
 	}
-	switch v.Kind() {
-	case reflect.Bool:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
-		if v.Bool() {
-			writeValue(out, tagByte, byte(1))
-		} else {
-			writeValue(out, tagByte, byte(0))
+}
+
+func (e *encodeState) writeU8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encodeState) writeU16(v uint16) {
+	e.buf = e.order.AppendUint16(e.buf, v)
+}
+
+func (e *encodeState) writeU32(v uint32) {
+	e.buf = e.order.AppendUint32(e.buf, v)
+}
+
+func (e *encodeState) writeU64(v uint64) {
+	e.buf = e.order.AppendUint64(e.buf, v)
+}
+
+// Writes the payload of a TAG_Int or an element of a TAG_Int_Array.
+func (e *encodeState) writeInt32(v uint32) {
+	if e.Dialect == BedrockNetwork {
+		e.buf = binary.AppendUvarint(e.buf, zigzag(int64(int32(v))))
+		return
+	}
+	e.writeU32(v)
+}
+
+// Writes the payload of a TAG_Long or an element of a TAG_Long_Array.
+func (e *encodeState) writeInt64(v uint64) {
+	if e.Dialect == BedrockNetwork {
+		e.buf = binary.AppendUvarint(e.buf, zigzag(int64(v)))
+		return
+	}
+	e.writeU64(v)
+}
+
+// Writes the length of a list or array.
+func (e *encodeState) writeLen(n int) {
+	e.writeInt32(uint32(n))
+}
+
+// Writes the length of a string.
+func (e *encodeState) writeStringLen(n int) {
+	if e.Dialect == BedrockNetwork {
+		e.buf = binary.AppendUvarint(e.buf, uint64(n))
+		return
+	}
+	e.writeU16(uint16(n))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (e *encodeState) writeString(s string) {
+	if e.Dialect == Java && !plainASCII(s) {
+		length := mutf8Len(s)
+		if n := e.checkLength(TagString, length, maxStringLength); n < length {
+			s = truncateMUTF8(s, n)
+			length = mutf8Len(s)
 		}
+		e.writeStringLen(length)
+		e.buf = appendMUTF8(e.buf, s)
+		return
+	}
 
-	case reflect.Int8:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
-		writeValue(out, tagByte, int8(v.Int()))
+	if n := e.checkLength(TagString, len(s), maxStringLength); n < len(s) {
+		s = truncateUTF8(s, n)
+	}
+	e.writeStringLen(len(s))
+	e.buf = append(e.buf, s...)
+}
 
-	case reflect.Uint8:
-		w(out, tagByte)
-		writeValue(out, tagString, name)
-		writeValue(out, tagByte, uint8(v.Uint()))
-
-	case reflect.Int16:
-		w(out, tagShort)
-		writeValue(out, tagString, name)
-		writeValue(out, tagShort, int16(v.Int()))
-
-	case reflect.Uint16:
-		w(out, tagShort)
-		writeValue(out, tagString, name)
-		writeValue(out, tagShort, uint16(v.Uint()))
-
-	case reflect.Int32:
-		w(out, tagInt)
-		writeValue(out, tagString, name)
-		writeValue(out, tagInt, int32(v.Int()))
-
-	case reflect.Uint32:
-		w(out, tagInt)
-		writeValue(out, tagString, name)
-		writeValue(out, tagInt, uint32(v.Uint()))
-
-	case reflect.Int64:
-		w(out, tagLong)
-		writeValue(out, tagString, name)
-		writeValue(out, tagLong, v.Int())
-
-	case reflect.Uint64:
-		w(out, tagLong)
-		writeValue(out, tagString, name)
-		writeValue(out, tagLong, v.Uint())
+const (
+	maxStringLength = math.MaxUint16
+	maxListLength   = math.MaxInt32
+)
 
-	case reflect.Float32:
-		w(out, tagFloat)
-		writeValue(out, tagString, name)
-		writeValue(out, tagFloat, float32(v.Float()))
+// A LengthError reports a string, list or array that is too long to encode.
+type LengthError struct {
+	Path   string // Where the value is, e.g. pages[3], or "" for the root tag.
+	Tag    Tag
+	Length int // In bytes for strings, elements otherwise.
+	Max    int
+}
 
-	case reflect.Float64:
-		w(out, tagDouble)
-		writeValue(out, tagString, name)
-		writeValue(out, tagDouble, v.Float())
+func (err *LengthError) Error() string {
+	if err.Path == "" {
+		return fmt.Sprintf("nbt: Root %s has length %d, but at most %d fits", err.Tag, err.Length, err.Max)
+	}
+	return fmt.Sprintf("nbt: %s at %s has length %d, but at most %d fits", err.Tag, err.Path, err.Length, err.Max)
+}
 
-	case reflect.String:
-		w(out, tagString)
-		writeValue(out, tagString, name)
-		writeValue(out, tagString, v.String())
+// Checks the length of something about to be written and returns how much of
+// it to actually write.
+func (e *encodeState) checkLength(tag Tag, length, max int) int {
+	if length <= max {
+		return length
+	}
 
-	case reflect.Array:
-		switch v.Type().Elem().Kind() {
-		case reflect.Uint8:
-			w(out, tagByteArray)
-			writeValue(out, tagString, name)
-			writeValue(out, tagByteArray, v.Slice(0, v.Len()).Bytes())
-
-		case reflect.Int32, reflect.Uint32:
-			w(out, tagIntArray)
-			writeValue(out, tagString, name)
-			for i := 0; i < v.Len(); i++ {
-				writeValue(out, tagInt, v.Index(i).Interface())
-			}
+	// The first element of the path is the root tag, which isn't named in
+	// paths.
+	var at []pathElem
+	if len(e.path) > 1 {
+		at = e.path[1:]
+	}
+	err := &LengthError{Path: formatPath(at), Tag: tag, Length: length, Max: max}
+	if !e.Truncate {
+		panic(err)
+	}
+	if e.OnTruncate != nil {
+		e.OnTruncate(err)
+	}
+	return max
+}
 
-		case reflect.Int64, reflect.Uint64:
-			w(out, tagLongArray)
-			writeValue(out, tagString, name)
-			w(out, uint32(v.Len()))
-			for i := 0; i < v.Len(); i++ {
-				writeValue(out, tagLong, v.Index(i).Interface())
-			}
+// Returns the longest prefix of s that is at most n bytes long and doesn't end
+// in the middle of a character.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Follows pointers and interfaces down to the value they hold.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return v
+}
+
+// Returns the tag that values of type t are encoded as. Slices are lists unless
+// the array option says otherwise.
+func typeTag(t reflect.Type, opts tagOptions) (Tag, bool) {
+	switch t {
+	case timeType:
+		return TagLong, true
+	case uuidType:
+		return TagIntArray, true
+	}
 
-		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem()))
+	switch {
+	case implements(t, marshalerType):
+		return TagCompound, true
+	case implements(t, textMarshalerType):
+		return TagString, true
+	case implements(t, binaryMarshalerType):
+		return TagByteArray, true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return TagByte, true
+	case reflect.Int16, reflect.Uint16:
+		return TagShort, true
+	case reflect.Int32, reflect.Uint32:
+		return TagInt, true
+	case reflect.Int64, reflect.Uint64:
+		return TagLong, true
+	case reflect.Float32:
+		return TagFloat, true
+	case reflect.Float64:
+		return TagDouble, true
+	case reflect.String:
+		return TagString, true
+
+	case reflect.Array:
+		if tag := arrayTag(t.Elem()); tag != TagEnd {
+			return tag, true
 		}
+		panic(fmt.Errorf("nbt: Unhandled array type: %v", t.Elem()))
 
 	case reflect.Slice:
-		w(out, tagList)
-		writeValue(out, tagString, name)
-		writeList(out, v)
+		if !opts.has("array") {
+			return TagList, true
+		}
+		if tag := arrayTag(t.Elem()); tag != TagEnd {
+			return tag, true
+		}
+		panic(fmt.Errorf("nbt: Unhandled array type: %v", t.Elem()))
+	case reflect.Map, reflect.Struct:
+		return TagCompound, true
+	case reflect.Ptr:
+		return typeTag(t.Elem(), opts)
+	}
+	return TagEnd, false
+}
 
-	case reflect.Map:
-		w(out, tagCompound)
-		writeValue(out, tagString, name)
-		writeMap(out, v)
+// Like typeTag, but for a value, whose tag may not depend on its type alone.
+func valueTag(v reflect.Value, opts tagOptions) (Tag, bool) {
+	if v.Type() == rawTagType {
+		return v.Interface().(RawTag).Tag, true
+	}
+	return typeTag(v.Type(), opts)
+}
 
-	case reflect.Struct:
-		w(out, tagCompound)
-		writeValue(out, tagString, name)
-		writeCompound(out, v)
+// The options for values held in interfaces, which have no struct tag to go by.
+// Unmarshal decodes array tags into slices, so such slices are written back as
+// arrays when they can be.
+var dynamicOpts = tagOptions{"array"}
 
-	default:
-		panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
+// Returns the tag of a value in a tree like the ones Unmarshal produces.
+func dynamicTag(v interface{}) (Tag, bool) {
+	rv := indirect(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return TagEnd, false
 	}
+	return valueTag(rv, dynamicOptions(rv))
 }
 
-func writeValue(out io.Writer, tag Tag, v interface{}) {
-	switch tag {
-	case tagByte, tagShort, tagInt, tagLong, tagFloat, tagDouble:
-		w(out, v)
+// Returns dynamicOpts for slices that can be arrays and no options otherwise.
+func dynamicOptions(v reflect.Value) tagOptions {
+	if v.Kind() == reflect.Slice && arrayTag(v.Type().Elem()) != TagEnd {
+		return dynamicOpts
+	}
+	return nil
+}
 
-	case tagString:
-		w(out, uint16(len(v.(string))))
-		_, err := out.Write([]byte(v.(string)))
-		if err != nil {
-			panic(err)
+// Returns the array tag for arrays with the given element type, or TagEnd.
+func arrayTag(elem reflect.Type) Tag {
+	switch elem.Kind() {
+	case reflect.Uint8:
+		return TagByteArray
+	case reflect.Int32, reflect.Uint32:
+		return TagIntArray
+	case reflect.Int64, reflect.Uint64:
+		return TagLongArray
+	}
+	return TagEnd
+}
+
+func (e *encodeState) writeTag(name string, v reflect.Value, opts tagOptions) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, "\n\t\tat struct field %#v", name))
 		}
+	}()
 
-	case tagByteArray:
-		w(out, uint32(len(v.([]byte))))
-		_, err := out.Write(v.([]byte))
-		if err != nil {
-			panic(err)
+	if v.Kind() == reflect.Ptr && v.IsNil() && len(e.path) > 0 {
+		// Entries are left out for nil pointers, so that a pointer field can
+		// hold an entry that isn't always there.
+		return
+	}
+	dynamic := v.Kind() == reflect.Interface
+	v = indirect(v)
+	if !v.IsValid() {
+		panic(fmt.Errorf("nbt: Unhandled type: nil"))
+	}
+	if dynamic {
+		opts = dynamicOptions(v)
+	}
+	if unit := durationUnit(opts); unit != 0 && v.Type() == durationType {
+		d := time.Duration(v.Int())
+		if d%unit != 0 {
+			panic(fmt.Errorf("nbt: Duration %v is not a whole number of %v", d, unit))
 		}
+		v = reflect.ValueOf(int64(d / unit))
+	}
+	tag, ok := valueTag(v, opts)
+	if !ok {
+		panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
+	}
 
-	default:
-		panic(fmt.Errorf("nbt: Unhandled tag: %s (%v)", tag, v))
+	e.path = append(e.path, pathElem{name, -1})
+	e.writeU8(byte(tag))
+	e.writeString(name)
+	e.writePayload(tag, v)
+	e.path = e.path[:len(e.path)-1]
+}
+
+// Returns the bits of an integer value, whether it is signed or not.
+func intBits(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
 	}
+	return v.Uint()
 }
 
-func writeList(out io.Writer, v reflect.Value) {
-	var tag Tag
-	mustConvertBool := false
-	mustConvertMap := false
-	switch v.Type().Elem().Kind() {
-	case reflect.Bool:
-		mustConvertBool = true
-		fallthrough
-	case reflect.Int8, reflect.Uint8:
-		tag = tagByte
+func (e *encodeState) writePayload(tag Tag, v reflect.Value) {
+	switch v.Type() {
+	case rawTagType:
+		raw := v.Interface().(RawTag)
+		if raw.Tag == TagEnd || raw.Payload == nil {
+			panic(fmt.Errorf("nbt: RawTag is empty"))
+		}
+		if raw.Dialect != e.Dialect {
+			panic(fmt.Errorf("nbt: RawTag holds %s NBT, but %s is being written", raw.Dialect, e.Dialect))
+		}
+		e.buf = append(e.buf, raw.Payload...)
+		return
+	case timeType:
+		e.writeInt64(uint64(v.Interface().(time.Time).UnixMilli()))
+		return
+	case uuidType:
+		id := v.Interface().(UUID)
+		e.writeLen(4)
+		for i := 0; i < len(id); i += 4 {
+			e.writeInt32(binary.BigEndian.Uint32(id[i:]))
+		}
+		return
+	}
 
-	case reflect.Int16, reflect.Uint16:
-		tag = tagShort
+	switch {
+	case tag == TagCompound && implements(v.Type(), marshalerType):
+		e.writeMarshaler(asInterface(v, marshalerType).(Marshaler))
+		return
+	case tag == TagString && implements(v.Type(), textMarshalerType):
+		text, err := asInterface(v, textMarshalerType).(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			panic(err)
+		}
+		e.writeString(string(text))
+		return
+	case tag == TagByteArray && implements(v.Type(), binaryMarshalerType):
+		data, err := asInterface(v, binaryMarshalerType).(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		n := e.checkLength(tag, len(data), maxListLength)
+		e.writeLen(n)
+		e.buf = append(e.buf, data[:n]...)
+		return
+	}
 
-	case reflect.Int32, reflect.Uint32:
-		tag = tagInt
+	switch tag {
+	case TagByte:
+		if v.Kind() == reflect.Bool {
+			if v.Bool() {
+				e.writeU8(1)
+			} else {
+				e.writeU8(0)
+			}
+		} else {
+			e.writeU8(uint8(intBits(v)))
+		}
 
-	case reflect.Int64, reflect.Uint64:
-		tag = tagLong
+	case TagShort:
+		e.writeU16(uint16(intBits(v)))
 
-	case reflect.Float32:
-		tag = tagFloat
+	case TagInt:
+		e.writeInt32(uint32(intBits(v)))
 
-	case reflect.Float64:
-		tag = tagDouble
+	case TagLong:
+		e.writeInt64(intBits(v))
 
-	case reflect.String:
-		tag = tagString
+	case TagFloat:
+		e.writeU32(math.Float32bits(float32(v.Float())))
 
-	case reflect.Array:
-		switch v.Type().Elem().Elem().Kind() {
-		case reflect.Uint8:
-			tag = tagByteArray
+	case TagDouble:
+		e.writeU64(math.Float64bits(v.Float()))
 
-		case reflect.Int32, reflect.Uint32:
-			tag = tagIntArray
+	case TagString:
+		e.writeString(v.String())
 
-		case reflect.Int64, reflect.Uint64:
-			tag = tagLongArray
+	case TagByteArray:
+		n := e.checkLength(tag, v.Len(), maxListLength)
+		e.writeLen(n)
+		if v.Kind() == reflect.Slice {
+			e.buf = append(e.buf, v.Bytes()[:n]...)
+		} else {
+			for i := 0; i < n; i++ {
+				e.writeU8(uint8(v.Index(i).Uint()))
+			}
+		}
 
-		default:
-			panic(fmt.Errorf("nbt: Unhandled array type: %v", v.Type().Elem().Elem()))
+	case TagIntArray:
+		n := e.checkLength(tag, v.Len(), maxListLength)
+		e.writeLen(n)
+		for i := 0; i < n; i++ {
+			e.writeInt32(uint32(intBits(v.Index(i))))
 		}
 
-	case reflect.Slice:
-		tag = tagList
+	case TagLongArray:
+		n := e.checkLength(tag, v.Len(), maxListLength)
+		e.writeLen(n)
+		for i := 0; i < n; i++ {
+			e.writeInt64(intBits(v.Index(i)))
+		}
 
-	case reflect.Map:
-		mustConvertMap = true
-		fallthrough
-	case reflect.Struct:
-		tag = tagCompound
+	case TagList:
+		e.writeList(v)
 
-	case reflect.Ptr: // TODO: Is there ever a case where tagCompound would be wrong here?
-		tag = tagCompound
+	case TagCompound:
+		if v.Kind() == reflect.Map {
+			e.writeMap(v)
+		} else {
+			e.writeCompound(v)
+		}
 
 	default:
-		panic(fmt.Errorf("nbt: Unhandled list element type: %v", v.Type().Elem()))
+		panic(fmt.Errorf("nbt: Unhandled tag: %s (%v)", tag, v))
+	}
+}
+
+func (e *encodeState) writeList(v reflect.Value) {
+	var tag Tag
+	if elem := v.Type().Elem(); elem.Kind() == reflect.Interface || elem == rawTagType {
+		tag = elementTag(v)
+	} else {
+		var ok bool
+		tag, ok = typeTag(v.Type().Elem(), nil)
+		if !ok {
+			panic(fmt.Errorf("nbt: Unhandled list element type: %v", v.Type().Elem()))
+		}
 	}
-	w(out, tag)
-	w(out, uint32(v.Len()))
+
+	n := e.checkLength(TagList, v.Len(), maxListLength)
+	e.writeU8(byte(tag))
+	e.writeLen(n)
 
 	var i int
 	defer func() {
 		if r := recover(); r != nil {
-			panic(fmt.Errorf("%v\n\t\tat list index %d", r, i))
+			panic(annotate(r, "\n\t\tat list index %d", i))
 		}
 	}()
-	for i = 0; i < v.Len(); i++ {
-		if mustConvertBool {
-			if v.Index(i).Bool() {
-				writeValue(out, tagByte, uint8(1))
-			} else {
-				writeValue(out, tagByte, uint8(0))
-			}
-		} else if tag == tagCompound {
-			if mustConvertMap {
-				writeMap(out, v.Index(i))
-			} else {
-				writeCompound(out, reflect.Indirect(v.Index(i)))
-			}
-		} else if tag == tagList {
-			writeList(out, v.Index(i))
-		} else if tag == tagByteArray {
-			writeValue(out, tag, v.Index(i).Bytes())
-		} else if tag == tagIntArray {
-			for j := 0; j < v.Index(i).Len(); j++ {
-				writeValue(out, tagInt, v.Index(i).Index(j).Interface())
-			}
-		} else if tag == tagLongArray {
-			for j := 0; j < v.Index(i).Len(); j++ {
-				writeValue(out, tagLong, v.Index(i).Index(j).Interface())
-			}
-		} else {
-			writeValue(out, tag, v.Index(i).Interface())
+	for i = 0; i < n; i++ {
+		el := indirect(v.Index(i))
+		if !el.IsValid() {
+			panic(fmt.Errorf("nbt: Unhandled type: nil"))
+		}
+		e.path = append(e.path, pathElem{index: i})
+		e.writePayload(tag, el)
+		e.path = e.path[:len(e.path)-1]
+	}
+}
+
+// Works out the element tag of a list whose static element type doesn't say,
+// such as the []interface{} values Unmarshal produces or a []RawTag. Empty lists have no
+// elements to go by, so like Minecraft we give them TAG_End.
+func elementTag(v reflect.Value) Tag {
+	tag := TagEnd
+	for i := 0; i < v.Len(); i++ {
+		el := indirect(v.Index(i))
+		if !el.IsValid() {
+			panic(annotate(fmt.Errorf("nbt: Unhandled type: nil"), "\n\t\tat list index %d", i))
+		}
+		t, ok := valueTag(el, dynamicOptions(el))
+		if !ok {
+			panic(annotate(fmt.Errorf("nbt: Unhandled list element type: %v", el.Type()), "\n\t\tat list index %d", i))
+		}
+		if i == 0 {
+			tag = t
+		} else if t != tag {
+			panic(fmt.Errorf("nbt: List elements must all have the same tag, but element 0 is %s and element %d is %s", tag, i, t))
 		}
 	}
+	return tag
 }
 
-func writeMap(out io.Writer, v reflect.Value) {
-	for _, name := range v.MapKeys() {
-		writeTag(out, name.String(), reflect.Indirect(v.MapIndex(name)))
+func (e *encodeState) writeMap(v reflect.Value) {
+	for _, key := range v.MapKeys() {
+		e.writeTag(mapKeyName(key), v.MapIndex(key), nil)
 	}
-	w(out, tagEnd)
+	e.writeU8(byte(TagEnd))
 }
 
-func writeCompound(out io.Writer, v reflect.Value) {
-	v = reflect.Indirect(v)
-	fields := parseStruct(v)
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+)
+
+// Reports whether t or *t implements the interface iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
 
-	for name, value := range fields {
-		writeTag(out, name, value)
+// Returns v as an interface{} that implements iface, making a pointer to it if
+// the methods have pointer receivers.
+func asInterface(v reflect.Value, iface reflect.Type) interface{} {
+	if v.Type().Implements(iface) {
+		return v.Interface()
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p.Interface()
+}
+
+// Returns the compound entry name for a map key.
+func mapKeyName(key reflect.Value) string {
+	if implements(key.Type(), textMarshalerType) {
+		name, err := asInterface(key, textMarshalerType).(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			panic(err)
+		}
+		return string(name)
+	}
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	panic(fmt.Errorf("nbt: Unhandled map key type: %v", key.Type()))
+}
+
+func (e *encodeState) writeCompound(v reflect.Value) {
+	fields := cachedFields(v.Type())
+	if key, ok := e.Registry.keyFor(v.Type()); ok {
+		if _, exists := fields.named(key); !exists {
+			e.writeTag(key, reflect.ValueOf(e.Registry.ids[v.Type()]), nil)
+		}
+	}
+
+	for _, f := range fields.list {
+		if (f.typ == uuidType || f.typ == uuidPtrType) && f.opts.has("mostleast") {
+			field := v.Field(f.index)
+			if field.Kind() == reflect.Ptr {
+				if field.IsNil() {
+					continue
+				}
+				field = field.Elem()
+			}
+			id := field.Interface().(UUID)
+			e.writeTag(f.name+"Most", reflect.ValueOf(binary.BigEndian.Uint64(id[:8])), nil)
+			e.writeTag(f.name+"Least", reflect.ValueOf(binary.BigEndian.Uint64(id[8:])), nil)
+			continue
+		}
+		e.writeTag(f.name, v.Field(f.index), f.opts)
 	}
-	w(out, tagEnd)
+	e.writeU8(byte(TagEnd))
 }
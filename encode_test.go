@@ -2,9 +2,18 @@ package nbt
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type Player struct {
@@ -101,3 +110,938 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestAppendMarshal(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/Nightgunner5.dat")
+	if err != nil {
+		t.Error(err)
+	}
+
+	var reference Player
+	err = Unmarshal(GZip, bytes.NewReader(data), &reference)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var marshaled bytes.Buffer
+	err = Marshal(Uncompressed, &marshaled, reference)
+	if err != nil {
+		t.Error(err)
+	}
+
+	prefix := []byte("packet header")
+	var enc Encoder
+	for i := 0; i < 2; i++ {
+		appended, err := enc.AppendMarshal(prefix, &reference)
+		if err != nil {
+			t.Error(err)
+		}
+		if !bytes.HasPrefix(appended, prefix) || !bytes.Equal(appended[len(prefix):], marshaled.Bytes()) {
+			t.Errorf("AppendMarshal output differs from Marshal output.")
+		}
+	}
+
+	out, err := AppendMarshal(prefix, make(chan int))
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	}
+	if !bytes.Equal(out, prefix) {
+		t.Errorf("AppendMarshal returned %q after an error, but expected %q.", out, prefix)
+	}
+
+	// One huge value doesn't make every later buffer huge.
+	_, err = enc.AppendMarshal(nil, map[string][]byte{"big": make([]byte, 10<<20)})
+	if err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 50; i++ {
+		_, err = enc.AppendMarshal(nil, int8(1))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	small, err := enc.AppendMarshal(nil, int8(1))
+	if err != nil {
+		t.Error(err)
+	}
+	if cap(small) > 1024 {
+		t.Errorf("Encoding an int8 allocated %d bytes", cap(small))
+	}
+}
+
+func TestModifiedUTF8(t *testing.T) {
+	type Sign struct {
+		Text string
+	}
+	sign := Sign{Text: "a\x00é☃😀"}
+
+	var buf bytes.Buffer
+	err := Marshal(Uncompressed, &buf, sign)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// As written by java.io.DataOutputStream.writeUTF.
+	expected := []byte{0x00, 0x0e, 'a', 0xc0, 0x80, 0xc3, 0xa9, 0xe2, 0x98, 0x83, 0xed, 0xa0, 0xbd, 0xed, 0xb8, 0x80}
+	if !bytes.Contains(buf.Bytes(), expected) {
+		t.Errorf("Encoded % x, which does not contain % x", buf.Bytes(), expected)
+	}
+
+	var result Sign
+	err = Unmarshal(Uncompressed, &buf, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Text", result.Text, sign.Text)
+
+	enc := Encoder{Dialect: Bedrock}
+	data, err := enc.AppendMarshal(nil, sign)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Contains(data, append([]byte{byte(len(sign.Text)), 0}, sign.Text...)) {
+		t.Errorf("Bedrock encoding % x does not contain a little endian length and UTF-8 text", data)
+	}
+
+	result = Sign{}
+	_, err = (&Decoder{Dialect: Bedrock}).UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Text", result.Text, sign.Text)
+}
+
+func TestStringTooLong(t *testing.T) {
+	type Book struct {
+		Pages []string `nbt:"pages"`
+	}
+	book := Book{Pages: []string{"short", strings.Repeat("é", 35000)}}
+
+	_, err := AppendMarshal(nil, book)
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) {
+		t.Fatalf("Expected a *LengthError, but got %v", err)
+	}
+	assertString(t, "Path", lengthErr.Path, "pages[1]")
+	if lengthErr.Length != 70000 || lengthErr.Max != 65535 {
+		t.Errorf("Length %d, max %d", lengthErr.Length, lengthErr.Max)
+	}
+
+	var warnings []*LengthError
+	enc := Encoder{Truncate: true, OnTruncate: func(err *LengthError) {
+		warnings = append(warnings, err)
+	}}
+	data, err := enc.AppendMarshal(nil, book)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("OnTruncate was called %d times, but expected once.", len(warnings))
+	}
+
+	var result Book
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Pages[1]", result.Pages[1], strings.Repeat("é", 32767))
+
+	// Other encoders report the same paths.
+	long := `"` + strings.Repeat("é", 35000) + `"`
+	for _, in := range []string{`{"pages":["short",` + long + `]}`, long} {
+		err = FromJSON(Uncompressed, ioutil.Discard, strings.NewReader(in), PlainJSON)
+		if !errors.As(err, &lengthErr) {
+			t.Fatalf("Expected a *LengthError, but got %v", err)
+		}
+		if in != long {
+			assertString(t, "Path", lengthErr.Path, "pages[1]")
+		}
+	}
+	assertString(t, "Error", lengthErr.Error(), "nbt: Root TAG_String (0x08) has length 70000, but at most 65535 fits")
+}
+
+func TestEncodeDecodedMap(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var reference map[string]interface{}
+	err = Unmarshal(GZip, f, &reference)
+	if err != nil {
+		t.Error(err)
+	}
+
+	data, err := AppendMarshal(nil, reference)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var result map[string]interface{}
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(result, reference) {
+		t.Errorf("Decoded %#v", result)
+		t.Logf("Expected %#v", reference)
+	}
+}
+
+func TestEncodeInterfaceList(t *testing.T) {
+	data, err := AppendMarshal(nil, map[string]interface{}{"empty": []interface{}{}})
+	if err != nil {
+		t.Error(err)
+	}
+	// TAG_Compound "", TAG_List "empty" of TAG_End with length 0, TAG_End.
+	expected := []byte{10, 0, 0, 9, 0, 5, 'e', 'm', 'p', 't', 'y', 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Encoded % x, but expected % x", data, expected)
+	}
+
+	_, err = AppendMarshal(nil, map[string]interface{}{"mixed": []interface{}{int32(1), "two"}})
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else if err.Error() != "nbt: List elements must all have the same tag, but element 0 is TAG_Int (0x03) and element 1 is TAG_String (0x08)\n\t\tat struct field \"mixed\"\n\t\tat struct field \"\"" {
+		t.Error(err)
+	}
+}
+
+func TestEncodeNilPointer(t *testing.T) {
+	type Entity struct {
+		ID         string
+		CustomName *string
+	}
+	data, err := AppendMarshal(nil, Entity{ID: "pig"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	var result map[string]interface{}
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"ID": "pig"}) {
+		t.Errorf("Decoded %#v", result)
+	}
+
+	_, err = AppendMarshal(nil, (*Entity)(nil))
+	if err == nil {
+		t.Error("Marshaled a nil root")
+	}
+}
+
+func TestEncodeSliceTags(t *testing.T) {
+	type Slices struct {
+		List  []int32
+		Array []int32 `nbt:",array"`
+		Any   interface{}
+	}
+	data, err := AppendMarshal(nil, Slices{[]int32{1}, []int32{2}, []int64{3}})
+	if err != nil {
+		t.Error(err)
+	}
+
+	var result map[string]interface{}
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := map[string]interface{}{
+		"List":  []interface{}{int32(1)},
+		"Array": []int32{2},
+		"Any":   []int64{3},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Decoded %#v, but expected %#v", result, expected)
+	}
+}
+
+func TestBuiltinTypes(t *testing.T) {
+	type Entity struct {
+		LastPlayed time.Time
+		Age        time.Duration `nbt:",ticks"`
+		Cooldown   time.Duration `nbt:"cooldown,millis"`
+		UUID       UUID
+		Owner      UUID  `nbt:",mostleast"`
+		Leash      *UUID `nbt:",mostleast"`
+		Target     *UUID `nbt:",mostleast"`
+	}
+
+	id, err := ParseUUID("069a79f4-44e9-4726-a5be-fca90e38aaf5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertString(t, "UUID", id.String(), "069a79f4-44e9-4726-a5be-fca90e38aaf5")
+
+	entity := Entity{
+		LastPlayed: time.UnixMilli(1602345678901),
+		Age:        30 * time.Second,
+		Cooldown:   1500 * time.Millisecond,
+		UUID:       id,
+		Owner:      id,
+		Leash:      &id,
+	}
+	data, err := AppendMarshal(nil, entity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var generic map[string]interface{}
+	_, err = UnmarshalBytes(data, &generic)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := map[string]interface{}{
+		"LastPlayed": int64(1602345678901),
+		"Age":        int64(600),
+		"cooldown":   int64(1500),
+		"UUID":       []int32{0x069a79f4, 0x44e94726, -0x5a410357, 0x0e38aaf5},
+		"OwnerMost":  int64(0x069a79f444e94726),
+		"OwnerLeast": int64(-0x5a410356f1c7550b),
+		"LeashMost":  int64(0x069a79f444e94726),
+		"LeashLeast": int64(-0x5a410356f1c7550b),
+	}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Encoded %#v", generic)
+		t.Logf("Expected %#v", expected)
+	}
+
+	var result Entity
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if !result.LastPlayed.Equal(entity.LastPlayed) || result.Age != entity.Age ||
+		result.Cooldown != entity.Cooldown || result.UUID != id || result.Owner != id ||
+		result.Leash == nil || *result.Leash != id || result.Target != nil {
+		t.Errorf("Decoded %+v", result)
+	}
+
+	// Durations aren't rounded to the unit they are stored in.
+	entity.Age = 75 * time.Millisecond
+	_, err = AppendMarshal(nil, entity)
+	if err == nil || !strings.Contains(err.Error(), "75ms is not a whole number of 50ms") {
+		t.Errorf("Encoding 1.5 ticks gave error %v", err)
+	}
+}
+
+type Vec3 struct {
+	X, Y, Z int8
+}
+
+func (v *Vec3) MarshalBinary() ([]byte, error) {
+	return []byte{byte(v.X), byte(v.Y), byte(v.Z)}, nil
+}
+
+func (v *Vec3) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return errors.New("Vec3 must be 3 bytes")
+	}
+	v.X, v.Y, v.Z = int8(data[0]), int8(data[1]), int8(data[2])
+	return nil
+}
+
+func TestTextAndBinaryMarshalers(t *testing.T) {
+	type Block struct {
+		ID     ResourceLocation `nbt:"id"`
+		Offset Vec3
+		Spawn  time.Time
+	}
+	block := Block{ID: ResourceLocation{"minecraft", "chest"}, Offset: Vec3{1, -2, 3}, Spawn: time.UnixMilli(1000)}
+
+	data, err := AppendMarshal(nil, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var generic map[string]interface{}
+	_, err = UnmarshalBytes(data, &generic)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := map[string]interface{}{
+		"id":     "minecraft:chest",
+		"Offset": []byte{1, 0xfe, 3},
+		"Spawn":  int64(1000),
+	}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Encoded %#v", generic)
+		t.Logf("Expected %#v", expected)
+	}
+
+	var result Block
+	_, err = UnmarshalBytes(data, &result)
+	if err != nil {
+		t.Error(err)
+	}
+	if result.ID != block.ID || result.Offset != block.Offset || !result.Spawn.Equal(block.Spawn) {
+		t.Errorf("Decoded %+v", result)
+	}
+}
+
+func TestFormatSNBT(t *testing.T) {
+	s, err := FormatSNBT(map[string]interface{}{
+		"Slot":  int8(3),
+		"id":    "minecraft:stone",
+		"tag":   map[string]interface{}{"display": map[string]interface{}{"Name": `"quoted"`}},
+		"a b":   []interface{}{float32(1.5), float32(-2)},
+		"ints":  []int32{1, -2},
+		"bytes": []byte{0xff},
+		"big":   int64(1) << 40,
+		"pi":    3.14,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "SNBT", s, `{Slot:3b,"a b":[1.5f,-2f],big:1099511627776L,bytes:[B;-1b],id:"minecraft:stone",ints:[I;1,-2],pi:3.14d,tag:{display:{Name:"\"quoted\""}}}`)
+
+	s, err = FormatSNBT(Food{Name: "Hampus", Value: 0.75})
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "SNBT of a struct", s, `{name:"Hampus",value:0.75f}`)
+
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var reference interface{}
+	err = Unmarshal(GZip, f, &reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = FormatSNBT(reference)
+	if err != nil {
+		t.Error(err)
+	}
+	parsed, err := ParseSNBT(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(parsed, reference) {
+		t.Errorf("Parsed %#v", parsed)
+		t.Logf("Expected %#v", reference)
+	}
+
+	// SNBT has no NaN or infinities.
+	for _, v := range []interface{}{math.NaN(), float32(math.Inf(1)), []interface{}{math.Inf(-1)}} {
+		if s, err := FormatSNBT(v); err == nil {
+			t.Errorf("Formatted %v as %s", v, s)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	data, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b map[string]interface{}
+	err = Unmarshal(GZip, bytes.NewReader(data), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Unmarshal(GZip, bytes.NewReader(data), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(a, b)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Diff of equal documents: %v %v", changes, err)
+	}
+
+	const byteArray = "byteArrayTest (the first 1000 values of (n*n*255+n*7)%100, starting with n=0 (0, 62, 34, 16, 8, ...))"
+	b["intTest"] = int32(1)
+	b["shortTest"] = "short"
+	delete(b, "byteTest")
+	b["new"] = int8(1)
+	b["nested compound test"].(map[string]interface{})["egg"].(map[string]interface{})["name"] = "Eggberta"
+	b["listTest (long)"] = append(b["listTest (long)"].([]interface{}), int64(16))
+	b[byteArray].([]byte)[3] = 1
+	b[byteArray].([]byte)[4] = 2
+	b[byteArray] = b[byteArray].([]byte)[:998]
+
+	changes, err = Diff(a, b)
+	if err != nil {
+		t.Error(err)
+	}
+	quoted := quote(byteArray)
+	assertString(t, "Diff", FormatDiff(changes), "- "+quoted+`[3:5]: [B;16b,8b]
++ `+quoted+`[3:5]: [B;1b,2b]
+- `+quoted+`[998:1000]: [B;6b,48b]
+- byteTest: 127b
+- intTest: 2147483647
++ intTest: 1
++ "listTest (long)"[5]: 16L
+- "nested compound test".egg.name: "Eggbert"
++ "nested compound test".egg.name: "Eggberta"
++ new: 1b
+- shortTest: 32767s
++ shortTest: "short"
+`)
+	if len(changes) != 8 || changes[0].Kind != Changed || changes[0].Start != 3 || changes[0].End != 5 || changes[1].Kind != Removed || changes[7].Kind != Retyped {
+		t.Errorf("Changes: %#v", changes)
+	}
+
+	// Streams are decoded first.
+	var modified bytes.Buffer
+	err = Marshal(GZip, &modified, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamChanges, err := DiffStreams(GZip, bytes.NewReader(data), &modified)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "DiffStreams", FormatDiff(streamChanges), FormatDiff(changes))
+
+	changes, err = Diff(map[string]interface{}{"x": 1.0}, map[string]interface{}{"x": math.NaN()})
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Diff with NaN", FormatDiff(changes), "- x: 1d\n+ x: NaN\n")
+}
+
+func TestMerge(t *testing.T) {
+	dst, _ := ParseSNBT(`{a:1,b:{x:1b,y:[1,2]},c:[I;1,2]}`)
+	src, _ := ParseSNBT(`{b:{y:[3],z:"z"},c:[I;3],d:{e:1L}}`)
+	Merge(dst.(map[string]interface{}), src.(map[string]interface{}))
+	s, _ := FormatSNBT(dst)
+	assertString(t, "Merged", s, `{a:1,b:{x:1b,y:[3],z:"z"},c:[I;3],d:{e:1L}}`)
+
+	// The merged values are copies.
+	src.(map[string]interface{})["b"].(map[string]interface{})["y"].([]interface{})[0] = int32(4)
+	s, _ = FormatSNBT(dst)
+	assertString(t, "Merged", s, `{a:1,b:{x:1b,y:[3],z:"z"},c:[I;3],d:{e:1L}}`)
+}
+
+func TestApply(t *testing.T) {
+	path := func(s string) *Path {
+		p, err := ParsePath(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	tree, _ := ParseSNBT(`{Inventory:[{Slot:0b,id:"stone",Count:1b},{Slot:3b,id:"dirt",Count:5b}],Pos:[0d,64d,0d],Data:[B;1b,2b]}`)
+	root := tree.(map[string]interface{})
+
+	err := Apply(root,
+		Operation{Op: OpSet, Path: path(`Inventory[{Slot:3b}].Count`), Value: int8(64)},
+		Operation{Op: OpSet, Path: path(`Pos[1]`), Value: 80.5},
+		Operation{Op: OpSet, Path: path(`Inventory[{Slot:5b}].id`), Value: "sand"},
+		Operation{Op: OpSet, Path: path(`abilities.flying`), Value: true},
+		Operation{Op: OpRemove, Path: path(`Inventory[].Count`)},
+		Operation{Op: OpAppend, Path: path(`Tags`), Value: "new"},
+		Operation{Op: OpInsert, Path: path(`Tags`), Index: 0, Value: "first"},
+		Operation{Op: OpInsert, Path: path(`Data`), Index: -2, Value: int8(9)},
+		Operation{Op: OpMerge, Path: path(`Inventory[0]`), Value: map[string]interface{}{"tag": map[string]interface{}{"Damage": int32(3)}}},
+		Operation{Op: OpRemove, Path: path(`Inventory[{id:"dirt"}]`)},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+	s, _ := FormatSNBT(root)
+	assertString(t, "Applied", s, `{Data:[B;1b,9b,2b],Inventory:[{Slot:0b,id:"stone",tag:{Damage:3}},{Slot:5b,id:"sand"}],Pos:[0d,80.5d,0d],Tags:["first","new"],abilities:{flying:1b}}`)
+
+	for _, op := range []Operation{
+		{Op: OpRemove, Path: path(`missing`)},
+		{Op: OpSet, Path: path(`Pos[0]`), Value: "north"},
+		{Op: OpSet, Path: path(`Pos[5]`), Value: 1.0},
+		{Op: OpInsert, Path: path(`Tags`), Index: 5, Value: "x"},
+		{Op: OpAppend, Path: path(`Data`), Value: int32(1)},
+		{Op: OpMerge, Path: path(`Pos`), Value: map[string]interface{}{}},
+		{Op: OpSet, Path: path(`{Data:[B;]}`), Value: map[string]interface{}{}},
+		{Op: OpSet, Path: path(`New.Deep[0]`), Value: int32(0)},
+	} {
+		if err := Apply(root, Operation{Op: OpSet, Path: path(`Pos[2]`), Value: 1.0}, op); err == nil {
+			t.Errorf("%s %s: expected an error", op.Op, op.Path)
+		}
+		// Failed operations, and the ones before them, leave no trace.
+		after, _ := FormatSNBT(root)
+		assertString(t, op.Op.String()+" "+op.Path.String(), after, s)
+	}
+	err = Apply(root, Operation{Op: OpSet, Path: path(`Pos[0]`), Value: "north"})
+	assertString(t, "Error", fmt.Sprint(err), "nbt: Can't put a TAG_String (0x08) in Pos[0], which holds TAG_Double (0x06) elements\n\t\tin operation 0 (set Pos[0])")
+}
+
+func TestJSON(t *testing.T) {
+	for file, compression := range map[string]Compression{
+		"testcases/bigtest.nbt":      GZip,
+		"testcases/Nightgunner5.dat": GZip,
+		"testcases/servers.dat":      Uncompressed,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var original bytes.Buffer
+		err = ToJSON(compression, bytes.NewReader(data), &original, TypedJSON)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		// Typed JSON converts back to the same bytes.
+		var encoded, uncompressed bytes.Buffer
+		err = FromJSON(Uncompressed, &encoded, bytes.NewReader(original.Bytes()), TypedJSON)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if compression == GZip {
+			z, _ := gzip.NewReader(bytes.NewReader(data))
+			uncompressed.ReadFrom(z)
+		} else {
+			uncompressed.Write(data)
+		}
+		if !bytes.Equal(encoded.Bytes(), uncompressed.Bytes()) {
+			t.Errorf("%s: Typed JSON round trip changed the document", file)
+		}
+	}
+
+	doc := map[string]interface{}{
+		"short": int16(5),
+		"float": float32(0.5),
+		"nan":   math.NaN(),
+		"bytes": []byte{1, 255},
+		"list":  []interface{}{"a", "b"},
+		"empty": []interface{}{},
+	}
+	data, err := AppendMarshal(nil, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain bytes.Buffer
+	err = ToJSON(Uncompressed, bytes.NewReader(data), &plain, PlainJSON)
+	if err != nil {
+		t.Error(err)
+	}
+	var decoded map[string]interface{}
+	err = json.Unmarshal(plain.Bytes(), &decoded)
+	if err != nil {
+		t.Error(err)
+	}
+	s, _ := json.Marshal(decoded)
+	assertString(t, "Plain JSON", string(s), `{"bytes":[1,-1],"empty":[],"float":0.5,"list":["a","b"],"nan":"NaN","short":5}`)
+
+	var typed bytes.Buffer
+	err = ToJSON(Uncompressed, bytes.NewReader(data), &typed, TypedJSON)
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(typed.String(), `"short":{"type":"short","value":5}`) || !strings.Contains(typed.String(), `"bytes":{"type":"byteArray","value":[1,-1]}`) {
+		t.Errorf("Typed JSON: %s", typed.String())
+	}
+
+	// Plain JSON converts back with guessed tags.
+	var guessed bytes.Buffer
+	err = FromJSON(Uncompressed, &guessed, strings.NewReader(`{"a":1,"b":[1,2.5,3],"c":[1,5000000000],"d":true,"e":{"f":"g"}}`), PlainJSON)
+	if err != nil {
+		t.Error(err)
+	}
+	var tree interface{}
+	_, err = UnmarshalBytes(guessed.Bytes(), &tree)
+	if err != nil {
+		t.Error(err)
+	}
+	snbt, _ := FormatSNBT(tree)
+	assertString(t, "From plain JSON", snbt, `{a:1,b:[1d,2.5d,3d],c:[1L,5000000000L],d:1b,e:{f:"g"}}`)
+
+	for _, bad := range []string{
+		`{"type":"short","value":70000}`,
+		`{"type":"list","value":[{"type":"int","value":1},{"type":"string","value":"x"}]}`,
+		`{"type":"unknown","value":1}`,
+		`{"type":"compound","value":{"a":{"type":"byte","value":"x"}}}`,
+	} {
+		if err := FromJSON(Uncompressed, ioutil.Discard, strings.NewReader(bad), TypedJSON); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestNeutral(t *testing.T) {
+	f, err := os.Open("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var reference interface{}
+	err = Unmarshal(GZip, f, &reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Go through JSON as a stand-in for a YAML or TOML library.
+	neutral, err := ToNeutral(reference)
+	if err != nil {
+		t.Error(err)
+	}
+	data, err := json.Marshal(neutral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var decoded interface{}
+	err = d.Decode(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := FromNeutral(decoded)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(result, reference) {
+		t.Errorf("Decoded %#v", result)
+		t.Logf("Expected %#v", reference)
+	}
+
+	tree, _ := ParseSNBT(`{Health:20s,Pos:[0d,64.5d],Data:[B;1b,-1b],id:"stone",n:5,short:{byte:1b}}`)
+	neutral, err = ToNeutral(tree)
+	if err != nil {
+		t.Error(err)
+	}
+	data, _ = json.Marshal(neutral)
+	assertString(t, "Neutral", string(data), `{"Data":{"byteArray":[1,-1]},"Health":{"short":20},"Pos":[{"double":0},64.5],"id":"stone","n":5,"short":{"compound":{"byte":{"byte":1}}}}`)
+
+	// The kinds of values YAML libraries decode to.
+	result, err = FromNeutral(map[interface{}]interface{}{
+		"enabled": true,
+		"count":   uint8(3),
+		"big":     1 << 40,
+		"list":    []int{1, 2},
+		"mixed":   []interface{}{1, 2.5},
+		"hinted":  map[interface{}]interface{}{"short": 7},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	snbt, _ := FormatSNBT(result)
+	assertString(t, "From neutral", snbt, `{big:1099511627776L,count:3,enabled:1b,hinted:7s,list:[1,2],mixed:[1d,2.5d]}`)
+
+	for _, bad := range []interface{}{
+		map[string]interface{}{"a": nil},
+		map[string]interface{}{"a": map[string]interface{}{"byte": 300}},
+		map[string]interface{}{"a": []interface{}{"x", 1}},
+		map[int]interface{}{1: 1},
+	} {
+		if _, err := FromNeutral(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+
+	_, err = FromNeutral(map[string]interface{}{"floats": map[string]interface{}{"float": []float64{1}}})
+	if err == nil {
+		t.Error("No error, but one was expected!")
+	} else {
+		assertString(t, "Error", err.Error(), "nbt: Expected a number, but found [1]\n\t\tin TAG_Float (0x05) hint\n\t\tat struct field \"floats\"")
+	}
+}
+
+func TestNamedRoot(t *testing.T) {
+	var buf bytes.Buffer
+	err := MarshalNamed(Uncompressed, &buf, "Level", map[string]interface{}{"a": int8(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root map[string]interface{}
+	name, err := UnmarshalNamed(Uncompressed, &buf, &root)
+	if err != nil {
+		t.Error(err)
+	}
+	assertString(t, "Root name", name, "Level")
+	if root["a"] != int8(1) {
+		t.Errorf("Decoded %#v", root)
+	}
+}
+
+func TestBedrockNetwork(t *testing.T) {
+	enc := Encoder{Dialect: BedrockNetwork}
+	data, err := enc.AppendMarshal(nil, map[string]interface{}{
+		"i": int32(-2),
+		"s": []int32{300},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range [][]byte{
+		{byte(TagInt), 1, 'i', 3},
+		{byte(TagIntArray), 1, 's', 2, 0xd8, 0x04},
+	} {
+		if !bytes.Contains(data, expected) {
+			t.Errorf("Encoded % x, which does not contain % x", data, expected)
+		}
+	}
+
+	original, err := ioutil.ReadFile("testcases/bigtest.nbt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var network, java bytes.Buffer
+	err = enc.Transcode(Uncompressed, &network, new(Decoder), GZip, bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := Decoder{Dialect: BedrockNetwork}
+	err = new(Encoder).Transcode(Uncompressed, &java, &dec, Uncompressed, bytes.NewReader(network.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected, result interface{}
+	if err := Unmarshal(GZip, bytes.NewReader(original), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.UnmarshalBytes(network.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Error("Decoding the network encoding gave a different tree")
+	}
+	plain, _ := ioutil.ReadAll(mustGunzip(t, original))
+	if !bytes.Equal(java.Bytes(), plain) {
+		t.Error("Transcoding to the network dialect and back changed the document")
+	}
+}
+
+func mustGunzip(t *testing.T, data []byte) io.Reader {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// An ItemStack encodes itself as nbtmarshal would have it.
+type ItemStack struct {
+	ID    string `nbt:"id"`
+	Count int8
+	Tags  []string
+}
+
+func (x *ItemStack) MarshalNBT(w *Writer) error {
+	w.Entry(TagString, "id")
+	w.String(x.ID)
+	w.Entry(TagByte, "Count")
+	w.Byte(x.Count)
+	w.Entry(TagList, "Tags")
+	for i := range x.Tags[:w.List(TagString, len(x.Tags))] {
+		w.String(x.Tags[i])
+	}
+	return nil
+}
+
+func (x *ItemStack) UnmarshalNBT(r *Reader) error {
+	for {
+		name, tag := r.Entry()
+		if tag == TagEnd {
+			return nil
+		}
+		switch name {
+		case "id":
+			x.ID = r.String(tag)
+		case "Count":
+			x.Count = r.Byte(tag)
+		case "Tags":
+			elem, n := r.List(tag)
+			if n == 0 {
+				x.Tags = x.Tags[:0]
+			} else {
+				x.Tags = make([]string, n)
+			}
+			for i := range x.Tags {
+				x.Tags[i] = r.String(elem)
+			}
+		default:
+			r.Unhandled(tag)
+		}
+	}
+}
+
+func TestMarshaler(t *testing.T) {
+	type Chest struct {
+		Items []ItemStack
+		Hand  *ItemStack
+	}
+	type plainItemStack struct {
+		ID    string `nbt:"id"`
+		Count int8
+		Tags  []string
+	}
+	type plainChest struct {
+		Items []plainItemStack
+		Hand  *plainItemStack
+	}
+	chest := Chest{
+		Items: []ItemStack{{"minecraft:stone", 64, []string{"a", "b"}}, {"minecraft:dirt", 1, nil}},
+		Hand:  &ItemStack{ID: "minecraft:stick", Count: 1},
+	}
+	plain := plainChest{
+		Items: []plainItemStack{{"minecraft:stone", 64, []string{"a", "b"}}, {"minecraft:dirt", 1, nil}},
+		Hand:  &plainItemStack{ID: "minecraft:stick", Count: 1},
+	}
+
+	for _, dialect := range []Dialect{Java, BedrockNetwork} {
+		enc, dec := Encoder{Dialect: dialect}, Decoder{Dialect: dialect}
+		data, err := enc.AppendMarshal(nil, chest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := enc.AppendMarshal(nil, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("%v: Encoded % x, but reflection encoded % x", dialect, data, expected)
+		}
+
+		var result Chest
+		if _, err := dec.UnmarshalBytes(expected, &result); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, chest) {
+			t.Errorf("%v: Decoded %+v", dialect, result)
+		}
+	}
+
+	// Reader methods convert other tags as reflection does, with the same
+	// errors.
+	data := mustMarshal(t, map[string]interface{}{"id": "x", "Tags": []byte{1}})
+	var item ItemStack
+	_, err := UnmarshalBytes(data, &item)
+	if err == nil || !strings.Contains(err.Error(), `at struct field "Tags"`) {
+		t.Errorf("Decoding a byte array of tags gave error %v", err)
+	}
+	data = mustMarshal(t, map[string]interface{}{"id": "x", "Damage": int32(1)})
+	_, err = UnmarshalBytes(data, &item)
+	if err == nil || !strings.Contains(err.Error(), "Unhandled TAG_Int") {
+		t.Errorf("Decoding an unknown entry gave error %v", err)
+	}
+	data = mustMarshal(t, map[string]interface{}{"id": "x", "Count": int16(3)})
+	dec := Decoder{ConvertNumbers: true}
+	if _, err := dec.UnmarshalBytes(data, &item); err != nil || item.Count != 3 {
+		t.Errorf("Decoded %+v, %v", item, err)
+	}
+}
+
+func TestParseStructTag(t *testing.T) {
+	for tag, want := range map[string][2]string{
+		"":                  {"", ""},
+		"data,array":        {"data", "array"},
+		"a,b":               {"a,b", ""},
+		"a,b,mostleast":     {"a,b", "mostleast"},
+		",array,millis,-":   {",array,millis,-", ""},
+		"Time,millis,array": {"Time", "millis,array"},
+	} {
+		if name, opts := ParseStructTag(tag); name != want[0] || opts != want[1] {
+			t.Errorf("%q parsed as %q, %q", tag, name, opts)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+)
+
+type JSONMode byte
+
+const (
+	// Plain JSON is for display: compounds are objects, lists and arrays are
+	// arrays and numbers are numbers, whatever their width. The root name is
+	// dropped. Converting plain JSON back to NBT has to guess the tags: whole
+	// numbers become TAG_Int or TAG_Long, other numbers TAG_Double, booleans
+	// TAG_Byte, and arrays TAG_List.
+	PlainJSON JSONMode = iota
+
+	// Typed JSON keeps everything needed to write the document back exactly
+	// as it was. Every tag is an object holding its type and value, and the
+	// root also holds its name:
+	//
+	//	{"name":"","type":"compound","value":{
+	//		"Health":{"type":"short","value":20},
+	//		"Tags":{"type":"list","value":[{"type":"string","value":"a"}]},
+	//		"Empty":{"type":"list","elementType":"compound","value":[]}}}
+	//
+	// The elements of a compound keep their order. elementType is only given
+	// for empty lists that have an element type other than end.
+	TypedJSON
+)
+
+// The type names used in typed JSON.
+var jsonTypes = [...]string{
+	TagEnd:       "end",
+	TagByte:      "byte",
+	TagShort:     "short",
+	TagInt:       "int",
+	TagLong:      "long",
+	TagFloat:     "float",
+	TagDouble:    "double",
+	TagByteArray: "byteArray",
+	TagString:    "string",
+	TagList:      "list",
+	TagCompound:  "compound",
+	TagIntArray:  "intArray",
+	TagLongArray: "longArray",
+}
+
+func jsonType(name string) Tag {
+	tag, ok := tagNamed(name)
+	if !ok {
+		panic(fmt.Errorf("nbt: Unknown tag type %q", name))
+	}
+	return tag
+}
+
+// Returns the tag with the given name in typed JSON.
+func tagNamed(name string) (Tag, bool) {
+	for tag, n := range jsonTypes {
+		if n == name {
+			return Tag(tag), true
+		}
+	}
+	return 0, false
+}
+
+// Converts an NBT document to JSON.
+func ToJSON(compression Compression, in io.Reader, out io.Writer, mode JSONMode) error {
+	return new(Decoder).ToJSON(compression, in, out, mode)
+}
+
+// Converts JSON made by ToJSON, or any JSON in the plain mode, to an NBT
+// document.
+func FromJSON(compression Compression, out io.Writer, in io.Reader, mode JSONMode) error {
+	return new(Encoder).FromJSON(compression, out, in, mode)
+}
+
+func (dec *Decoder) ToJSON(compression Compression, in io.Reader, out io.Writer, mode JSONMode) (err error) {
+	defer recoverError(&err)
+
+	w := &jsonWriter{decodeState: newDecodeState(dec).init(compression, in), typed: mode == TypedJSON}
+	name, tag := w.readTag()
+	if w.typed {
+		w.buf = append(w.buf, `{"name":`...)
+		w.buf = appendJSONString(w.buf, name)
+		w.buf = append(w.buf, ',')
+		w.typedFields(tag)
+	} else {
+		w.payload(tag)
+	}
+	w.buf = append(w.buf, '\n')
+
+	_, err = out.Write(w.buf)
+	return
+}
+
+type jsonWriter struct {
+	*decodeState
+	typed bool
+	buf   []byte
+}
+
+// Writes a tag as {"type":...,"value":...}.
+func (w *jsonWriter) typedValue(tag Tag) {
+	w.buf = append(w.buf, '{')
+	w.typedFields(tag)
+}
+
+// Writes the rest of a typed tag after the opening brace.
+func (w *jsonWriter) typedFields(tag Tag) {
+	w.buf = append(w.buf, `"type":"`...)
+	w.buf = append(w.buf, jsonTypes[tag]...)
+	w.buf = append(w.buf, `",`...)
+
+	if tag == TagList {
+		inner := Tag(w.readU8())
+		length := w.readLen()
+		if length == 0 && inner != TagEnd {
+			w.buf = append(w.buf, `"elementType":"`...)
+			w.buf = append(w.buf, jsonTypes[inner]...)
+			w.buf = append(w.buf, `",`...)
+		}
+		w.buf = append(w.buf, `"value":[`...)
+		for i := 0; i < length; i++ {
+			if i > 0 {
+				w.buf = append(w.buf, ',')
+			}
+			w.typedValue(inner)
+		}
+		w.buf = append(w.buf, "]}"...)
+		return
+	}
+
+	w.buf = append(w.buf, `"value":`...)
+	w.payload(tag)
+	w.buf = append(w.buf, '}')
+}
+
+func (w *jsonWriter) payload(tag Tag) {
+	switch tag {
+	case TagByte:
+		w.buf = strconv.AppendInt(w.buf, int64(int8(w.readU8())), 10)
+	case TagShort:
+		w.buf = strconv.AppendInt(w.buf, int64(int16(w.readU16())), 10)
+	case TagInt:
+		w.buf = strconv.AppendInt(w.buf, int64(int32(w.readInt32())), 10)
+	case TagLong:
+		w.buf = strconv.AppendInt(w.buf, int64(w.readInt64()), 10)
+	case TagFloat:
+		w.buf = appendJSONFloat(w.buf, float64(math.Float32frombits(w.readU32())), 32)
+	case TagDouble:
+		w.buf = appendJSONFloat(w.buf, math.Float64frombits(w.readU64()), 64)
+	case TagString:
+		w.buf = appendJSONString(w.buf, w.readString())
+
+	case TagByteArray, TagIntArray, TagLongArray:
+		length := w.readLen()
+		w.buf = append(w.buf, '[')
+		for i := 0; i < length; i++ {
+			if i > 0 {
+				w.buf = append(w.buf, ',')
+			}
+			switch tag {
+			case TagByteArray:
+				w.payload(TagByte)
+			case TagIntArray:
+				w.payload(TagInt)
+			case TagLongArray:
+				w.payload(TagLong)
+			}
+		}
+		w.buf = append(w.buf, ']')
+
+	case TagList:
+		inner := Tag(w.readU8())
+		length := w.readLen()
+		w.buf = append(w.buf, '[')
+		for i := 0; i < length; i++ {
+			if i > 0 {
+				w.buf = append(w.buf, ',')
+			}
+			w.payload(inner)
+		}
+		w.buf = append(w.buf, ']')
+
+	case TagCompound:
+		w.buf = append(w.buf, '{')
+		for first := true; ; first = false {
+			name, tag := w.readTag()
+			if tag == TagEnd {
+				break
+			}
+			if !first {
+				w.buf = append(w.buf, ',')
+			}
+			w.buf = appendJSONString(w.buf, name)
+			w.buf = append(w.buf, ':')
+			if w.typed {
+				w.typedValue(tag)
+			} else {
+				w.payload(tag)
+			}
+		}
+		w.buf = append(w.buf, '}')
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
+	}
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	quoted, _ := json.Marshal(s)
+	return append(b, quoted...)
+}
+
+// Formats a number, or a string for the values JSON has no numbers for.
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(b, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(b, `"-Infinity"`...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bits)
+}
+
+func (enc *Encoder) FromJSON(compression Compression, out io.Writer, in io.Reader, mode JSONMode) (err error) {
+	defer recoverError(&err)
+
+	if out == nil {
+		panic(fmt.Errorf("nbt: Output stream is nil"))
+	}
+	if compression > ZLib {
+		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+	}
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		panic(err)
+	}
+
+	e := encodeState{Encoder: enc, order: enc.Dialect.byteOrder()}
+	if mode == TypedJSON {
+		var root typedJSON
+		unmarshalJSON(data, &root)
+		tag := jsonType(root.Type)
+		e.path = append(e.path, pathElem{root.Name, -1})
+		e.writeU8(byte(tag))
+		e.writeString(root.Name)
+		e.typedPayload(tag, root)
+	} else {
+		tag := plainTag(data)
+		e.path = append(e.path, pathElem{"", -1})
+		e.writeU8(byte(tag))
+		e.writeString("")
+		e.plainPayload(tag, data)
+	}
+
+	return writeCompressed(compression, out, e.buf)
+}
+
+type typedJSON struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	ElementType string          `json:"elementType"`
+	Value       json.RawMessage `json:"value"`
+}
+
+func unmarshalJSON(data []byte, v interface{}) {
+	if err := json.Unmarshal(data, v); err != nil {
+		panic(fmt.Errorf("nbt: Invalid JSON: %v", err))
+	}
+}
+
+// Calls f for each entry of a JSON object, in order.
+func jsonEntries(data []byte, f func(name string, value json.RawMessage)) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := d.Token(); err != nil || tok != json.Delim('{') {
+		panic(fmt.Errorf("nbt: Expected a JSON object, but found %s", data))
+	}
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			panic(fmt.Errorf("nbt: Invalid JSON: %v", err))
+		}
+		var value json.RawMessage
+		if err := d.Decode(&value); err != nil {
+			panic(fmt.Errorf("nbt: Invalid JSON: %v", err))
+		}
+		f(tok.(string), value)
+	}
+}
+
+// Parses a JSON number, or one of the strings appendJSONFloat uses.
+func parseJSONNumber(data []byte, tag Tag) (int64, float64) {
+	var n json.Number
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		unmarshalJSON(data, &s)
+		n = json.Number(s)
+	} else {
+		unmarshalJSON(data, &n)
+	}
+
+	bits := 64
+	switch tag {
+	case TagByte:
+		bits = 8
+	case TagShort:
+		bits = 16
+	case TagInt, TagFloat:
+		bits = 32
+	}
+
+	var err error
+	var i int64
+	var f float64
+	if tag == TagFloat || tag == TagDouble {
+		f, err = strconv.ParseFloat(n.String(), bits)
+	} else {
+		i, err = strconv.ParseInt(n.String(), 10, bits)
+	}
+	if err != nil {
+		panic(fmt.Errorf("nbt: Invalid %s value %s", tag, data))
+	}
+	return i, f
+}
+
+// Writes a payload that ends in a number, string or array, whose JSON form
+// is the same in both modes.
+func (e *encodeState) jsonScalar(tag Tag, data []byte) {
+	switch tag {
+	case TagByte:
+		if string(data) == "true" || string(data) == "false" {
+			if string(data) == "true" {
+				e.writeU8(1)
+			} else {
+				e.writeU8(0)
+			}
+			return
+		}
+		i, _ := parseJSONNumber(data, tag)
+		e.writeU8(uint8(i))
+	case TagShort:
+		i, _ := parseJSONNumber(data, tag)
+		e.writeU16(uint16(i))
+	case TagInt:
+		i, _ := parseJSONNumber(data, tag)
+		e.writeInt32(uint32(i))
+	case TagLong:
+		i, _ := parseJSONNumber(data, tag)
+		e.writeInt64(uint64(i))
+	case TagFloat:
+		_, f := parseJSONNumber(data, tag)
+		e.writeU32(math.Float32bits(float32(f)))
+	case TagDouble:
+		_, f := parseJSONNumber(data, tag)
+		e.writeU64(math.Float64bits(f))
+	case TagString:
+		var s string
+		unmarshalJSON(data, &s)
+		e.writeString(s)
+
+	case TagByteArray, TagIntArray, TagLongArray:
+		var elements []json.RawMessage
+		unmarshalJSON(data, &elements)
+		n := e.checkLength(tag, len(elements), maxListLength)
+		e.writeLen(n)
+		for _, el := range elements[:n] {
+			switch tag {
+			case TagByteArray:
+				e.jsonScalar(TagByte, el)
+			case TagIntArray:
+				e.jsonScalar(TagInt, el)
+			case TagLongArray:
+				e.jsonScalar(TagLong, el)
+			}
+		}
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
+	}
+}
+
+func (e *encodeState) typedPayload(tag Tag, v typedJSON) {
+	switch tag {
+	case TagList:
+		var elements []json.RawMessage
+		unmarshalJSON(v.Value, &elements)
+		typed := make([]typedJSON, len(elements))
+		inner := TagEnd
+		if v.ElementType != "" {
+			inner = jsonType(v.ElementType)
+		}
+		for i, el := range elements {
+			unmarshalJSON(el, &typed[i])
+			if tag := jsonType(typed[i].Type); i == 0 {
+				inner = tag
+			} else if tag != inner {
+				panic(fmt.Errorf("nbt: List elements must all have the same tag, but element 0 is %s and element %d is %s", inner, i, tag))
+			}
+		}
+		e.jsonList(inner, len(typed), func(i int) {
+			e.typedPayload(inner, typed[i])
+		})
+
+	case TagCompound:
+		jsonEntries(v.Value, func(name string, value json.RawMessage) {
+			var entry typedJSON
+			unmarshalJSON(value, &entry)
+			e.jsonEntry(name, jsonType(entry.Type), func() {
+				e.typedPayload(jsonType(entry.Type), entry)
+			})
+		})
+		e.writeU8(byte(TagEnd))
+
+	default:
+		e.jsonScalar(tag, v.Value)
+	}
+}
+
+func (e *encodeState) plainPayload(tag Tag, data []byte) {
+	switch tag {
+	case TagList:
+		var elements []json.RawMessage
+		unmarshalJSON(data, &elements)
+		inner := TagEnd
+		for i, el := range elements {
+			tag := plainTag(el)
+			switch {
+			case i == 0 || tag == inner:
+				inner = tag
+			case isPlainNumber(tag) && isPlainNumber(inner):
+				// Whole numbers are widened to fit the rest of the list.
+				if tag > inner {
+					inner = tag
+				}
+			default:
+				panic(fmt.Errorf("nbt: List elements must all have the same tag, but element 0 is %s and element %d is %s", inner, i, tag))
+			}
+		}
+		e.jsonList(inner, len(elements), func(i int) {
+			e.plainPayload(inner, elements[i])
+		})
+
+	case TagCompound:
+		jsonEntries(data, func(name string, value json.RawMessage) {
+			tag := plainTag(value)
+			e.jsonEntry(name, tag, func() {
+				e.plainPayload(tag, value)
+			})
+		})
+		e.writeU8(byte(TagEnd))
+
+	default:
+		e.jsonScalar(tag, data)
+	}
+}
+
+func isPlainNumber(tag Tag) bool {
+	return tag == TagInt || tag == TagLong || tag == TagDouble
+}
+
+// Guesses the tag for a plain JSON value.
+func plainTag(data []byte) Tag {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		panic(fmt.Errorf("nbt: Expected a JSON value"))
+	}
+	switch data[0] {
+	case '{':
+		return TagCompound
+	case '[':
+		return TagList
+	case '"':
+		return TagString
+	case 't', 'f':
+		return TagByte
+	case 'n':
+		panic(fmt.Errorf("nbt: Can't convert JSON null to NBT"))
+	}
+	if _, err := strconv.ParseInt(string(data), 10, 32); err == nil {
+		return TagInt
+	}
+	if _, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return TagLong
+	}
+	return TagDouble
+}
+
+func (e *encodeState) jsonList(tag Tag, length int, element func(i int)) {
+	n := e.checkLength(TagList, length, maxListLength)
+	e.writeU8(byte(tag))
+	e.writeLen(n)
+
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, "\n\t\tat list index %d", i))
+		}
+	}()
+	for i = 0; i < n; i++ {
+		e.path = append(e.path, pathElem{index: i})
+		element(i)
+		e.path = e.path[:len(e.path)-1]
+	}
+}
+
+func (e *encodeState) jsonEntry(name string, tag Tag, payload func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, "\n\t\tat struct field %#v", name))
+		}
+	}()
+
+	e.path = append(e.path, pathElem{name, -1})
+	e.writeU8(byte(tag))
+	e.writeString(name)
+	payload()
+	e.path = e.path[:len(e.path)-1]
+}
@@ -0,0 +1,389 @@
+package nbt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Marshaler is implemented by types that encode themselves as a TAG_Compound
+// without reflection, such as the ones cmd/nbtmarshal generates. MarshalNBT
+// writes the entries of the compound with w; the end tag is written after it
+// returns.
+type Marshaler interface {
+	MarshalNBT(w *Writer) error
+}
+
+// Unmarshaler is implemented by types that decode themselves from a
+// TAG_Compound. UnmarshalNBT reads the entries of the compound with r, up to
+// and including the end tag.
+type Unmarshaler interface {
+	UnmarshalNBT(r *Reader) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// A Writer writes tags for a Marshaler, in the dialect and with the options
+// of the Encoder that called it. It is only valid during the call to
+// MarshalNBT. Problems with the output, like a string that is too long, are
+// reported to the Encoder by panicking, so MarshalNBT needs no error handling
+// for them.
+//
+// An entry is written with Entry, followed by its payload.
+type Writer struct {
+	e    *encodeState
+	name string // Of the last entry, for errors.
+}
+
+// Writes the tag and name of a compound entry.
+func (w *Writer) Entry(tag Tag, name string) {
+	w.name = name
+	w.e.writeU8(byte(tag))
+	w.e.writeString(name)
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.e.writeU8(1)
+	} else {
+		w.e.writeU8(0)
+	}
+}
+
+func (w *Writer) Byte(v int8) {
+	w.e.writeU8(uint8(v))
+}
+
+func (w *Writer) Short(v int16) {
+	w.e.writeU16(uint16(v))
+}
+
+func (w *Writer) Int(v int32) {
+	w.e.writeInt32(uint32(v))
+}
+
+func (w *Writer) Long(v int64) {
+	w.e.writeInt64(uint64(v))
+}
+
+func (w *Writer) Float(v float32) {
+	w.e.writeU32(math.Float32bits(v))
+}
+
+func (w *Writer) Double(v float64) {
+	w.e.writeU64(math.Float64bits(v))
+}
+
+func (w *Writer) String(v string) {
+	w.e.writeString(v)
+}
+
+func (w *Writer) ByteArray(v []byte) {
+	n := w.e.checkLength(TagByteArray, len(v), maxListLength)
+	w.e.writeLen(n)
+	w.e.buf = append(w.e.buf, v[:n]...)
+}
+
+func (w *Writer) IntArray(v []int32) {
+	n := w.e.checkLength(TagIntArray, len(v), maxListLength)
+	w.e.writeLen(n)
+	for _, el := range v[:n] {
+		w.e.writeInt32(uint32(el))
+	}
+}
+
+func (w *Writer) LongArray(v []int64) {
+	n := w.e.checkLength(TagLongArray, len(v), maxListLength)
+	w.e.writeLen(n)
+	for _, el := range v[:n] {
+		w.e.writeInt64(uint64(el))
+	}
+}
+
+// Starts a list and returns the number of elements to write, which is less
+// than length if the list is too long and the Encoder truncates.
+func (w *Writer) List(elem Tag, length int) int {
+	n := w.e.checkLength(TagList, length, maxListLength)
+	w.e.writeU8(byte(elem))
+	w.e.writeLen(n)
+	return n
+}
+
+// Writes the payload of a compound with v's MarshalNBT method.
+func (w *Writer) Compound(v Marshaler) {
+	w.e.writeMarshaler(v)
+}
+
+// Writes a compound entry with reflection, as Marshal would write a struct
+// field with the given nbt struct tag options. It is for the fields generated
+// code doesn't handle itself.
+func (w *Writer) Value(name string, v interface{}, opts string) {
+	w.name = name
+	w.e.writeTag(name, reflect.ValueOf(v), splitOptions(opts))
+}
+
+// Splits an nbt struct tag into its name and options the way Marshal and
+// Unmarshal do, for code that handles structs itself. The options are
+// comma-separated, as Writer.Value and Reader.Value take them.
+func ParseStructTag(tag string) (name, opts string) {
+	name, list := parseTag(tag)
+	for i := len(list) - 1; i >= 0; i-- {
+		if opts != "" {
+			opts += ","
+		}
+		opts += list[i]
+	}
+	return name, opts
+}
+
+func splitOptions(opts string) tagOptions {
+	if opts == "" {
+		return nil
+	}
+	return tagOptions(strings.Split(opts, ","))
+}
+
+func (e *encodeState) writeMarshaler(v Marshaler) {
+	if e.Registry != nil {
+		t := reflect.TypeOf(v)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if key, ok := e.Registry.keyFor(t); ok {
+			if _, exists := cachedFields(t).named(key); !exists {
+				e.writeTag(key, reflect.ValueOf(e.Registry.ids[t]), nil)
+			}
+		}
+	}
+
+	w := &Writer{e: e}
+	defer func() {
+		if r := recover(); r != nil {
+			if w.name != "" {
+				r = annotate(r, "\n\t\tat struct field %#v", w.name)
+			}
+			panic(r)
+		}
+	}()
+	if err := v.MarshalNBT(w); err != nil {
+		panic(err)
+	}
+	w.name = ""
+	e.writeU8(byte(TagEnd))
+}
+
+// A Reader reads tags for an Unmarshaler, in the dialect and with the options
+// of the Decoder that called it. It is only valid during the call to
+// UnmarshalNBT. Problems with the input are reported to the Decoder by
+// panicking, so UnmarshalNBT needs no error handling for them.
+//
+// Entry returns the next compound entry, and the other methods read its
+// payload. Each takes the entry's tag, and decodes other tags as Unmarshal
+// would, with the same errors.
+type Reader struct {
+	d             *decodeState
+	discriminator string // Skipped by Entry if hasKey is set.
+	hasKey        bool
+	name          string // Of the last entry, for errors.
+}
+
+// Returns the name and tag of the next compound entry. The tag is TagEnd at
+// the end of the compound. The entry a Registry adds to tell types apart is
+// skipped.
+func (r *Reader) Entry() (string, Tag) {
+	for {
+		name, tag := r.d.readTag()
+		if r.hasKey && name == r.discriminator && tag == TagString {
+			r.d.readString()
+			continue
+		}
+		r.name = name
+		return name, tag
+	}
+}
+
+// Decodes a payload with reflection.
+func (r *Reader) read(tag Tag, v interface{}) {
+	r.d.readValue(tag, reflect.ValueOf(v).Elem())
+}
+
+func (r *Reader) Bool(tag Tag) bool {
+	if tag == TagByte {
+		return r.d.readU8() != 0
+	}
+	var v bool
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Byte(tag Tag) int8 {
+	if tag == TagByte {
+		return int8(r.d.readU8())
+	}
+	var v int8
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Short(tag Tag) int16 {
+	if tag == TagShort {
+		return int16(r.d.readU16())
+	}
+	var v int16
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Int(tag Tag) int32 {
+	if tag == TagInt {
+		return int32(r.d.readInt32())
+	}
+	var v int32
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Long(tag Tag) int64 {
+	if tag == TagLong {
+		return int64(r.d.readInt64())
+	}
+	var v int64
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Float(tag Tag) float32 {
+	if tag == TagFloat {
+		return math.Float32frombits(r.d.readU32())
+	}
+	var v float32
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) Double(tag Tag) float64 {
+	if tag == TagDouble {
+		return math.Float64frombits(r.d.readU64())
+	}
+	var v float64
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) String(tag Tag) string {
+	if tag == TagString {
+		return r.d.readString()
+	}
+	var v string
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) ByteArray(tag Tag) []byte {
+	if tag == TagByteArray {
+		return r.d.readBytes(r.d.readLen())
+	}
+	var v []byte
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) IntArray(tag Tag) []int32 {
+	if tag == TagIntArray {
+		var v []int32
+		if n := r.d.readLen(); n > 0 {
+			v = make([]int32, n)
+		}
+		for i := range v {
+			v[i] = int32(r.d.readInt32())
+		}
+		return v
+	}
+	var v []int32
+	r.read(tag, &v)
+	return v
+}
+
+func (r *Reader) LongArray(tag Tag) []int64 {
+	if tag == TagLongArray {
+		var v []int64
+		if n := r.d.readLen(); n > 0 {
+			v = make([]int64, n)
+		}
+		for i := range v {
+			v[i] = int64(r.d.readInt64())
+		}
+		return v
+	}
+	var v []int64
+	r.read(tag, &v)
+	return v
+}
+
+// Starts reading a list and returns the tag and number of its elements. Arrays
+// are read as lists of their elements, as Unmarshal reads them into slices.
+func (r *Reader) List(tag Tag) (elem Tag, length int) {
+	switch tag {
+	case TagList:
+		return Tag(r.d.readU8()), r.d.readLen()
+	case TagByteArray:
+		return TagByte, r.d.readLen()
+	case TagIntArray:
+		return TagInt, r.d.readLen()
+	case TagLongArray:
+		return TagLong, r.d.readLen()
+	}
+	panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a slice!", tag))
+}
+
+// Reads the payload of a compound with v's UnmarshalNBT method.
+func (r *Reader) Compound(tag Tag, v Unmarshaler) {
+	if tag != TagCompound {
+		panic(fmt.Errorf("nbt: Tag is %s, but I don't know how to put that in a %T!", tag, v))
+	}
+	r.d.readUnmarshaler(v)
+}
+
+// Skips a payload.
+func (r *Reader) Skip(tag Tag) {
+	r.d.skip(tag)
+}
+
+// Reports an entry the Unmarshaler has no field for, as Unmarshal does.
+func (r *Reader) Unhandled(tag Tag) {
+	panic(fmt.Errorf("nbt: Unhandled %s", tag))
+}
+
+// Decodes a payload into v, which must be a pointer, with reflection, as
+// Unmarshal would decode a struct field with the given nbt struct tag
+// options. It is for the fields generated code doesn't handle itself.
+func (r *Reader) Value(tag Tag, v interface{}, opts string) {
+	r.d.readField(tag, reflect.ValueOf(v).Elem(), splitOptions(opts))
+}
+
+func (d *decodeState) readUnmarshaler(v Unmarshaler) {
+	r := &Reader{d: d}
+	if d.Registry != nil {
+		t := reflect.TypeOf(v)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		r.discriminator, r.hasKey = d.discriminator(t)
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			if r.name != "" {
+				rec = annotate(rec, "\n\t\tat struct field %#v", r.name)
+			}
+			panic(rec)
+		}
+	}()
+	if err := v.UnmarshalNBT(r); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,353 @@
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Merges src into dst the way Minecraft's /data merge command does: entries
+// of src replace the entries of dst with the same name, except that where both
+// are compounds, they are merged recursively. Lists and arrays are replaced
+// as a whole. Values from src are copied, so src can be reused.
+func Merge(dst, src map[string]interface{}) {
+	for name, v := range src {
+		if from, ok := v.(map[string]interface{}); ok {
+			if into, ok := dst[name].(map[string]interface{}); ok {
+				Merge(into, from)
+				continue
+			}
+		}
+		dst[name] = deepCopy(v)
+	}
+}
+
+// Returns a copy of a tree that shares no maps or slices with it.
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for name, el := range v {
+			c[name] = deepCopy(el)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, el := range v {
+			c[i] = deepCopy(el)
+		}
+		return c
+	case []byte:
+		return append([]byte{}, v...)
+	case []int32:
+		return append([]int32{}, v...)
+	case []int64:
+		return append([]int64{}, v...)
+	}
+	return v
+}
+
+type OpKind byte
+
+const (
+	OpSet    OpKind = iota // Set the tags at Path to Value, creating them if needed.
+	OpRemove               // Remove the tags at Path.
+	OpAppend               // Add Value to the end of the lists or arrays at Path.
+	OpInsert               // Insert Value into the lists or arrays at Path before Index.
+	OpMerge                // Merge the compound Value into the compounds at Path.
+)
+
+func (op OpKind) String() string {
+	switch op {
+	case OpSet:
+		return "set"
+	case OpRemove:
+		return "remove"
+	case OpAppend:
+		return "append"
+	case OpInsert:
+		return "insert"
+	case OpMerge:
+		return "merge"
+	}
+	return fmt.Sprintf("OpKind(%d)", byte(op))
+}
+
+// An Operation is one change to make to a document, like the ones Minecraft's
+// /data modify command makes.
+type Operation struct {
+	Op   OpKind
+	Path *Path
+
+	// For OpInsert, the position to insert at. Negative values count from the
+	// end, so -1 is the same as OpAppend.
+	Index int
+
+	// The value to set, add or merge. Values other than the ones Unmarshal
+	// produces when decoding into an interface{} are converted as for Diff.
+	Value interface{}
+}
+
+// Applies operations in order to a tree of values like the ones Unmarshal
+// produces when decoding into an interface{}. Each operation applies to every
+// tag its path selects, and it is an error for a path to select nothing. As
+// in Minecraft, OpSet creates missing compounds along the way, and OpAppend,
+// OpInsert and OpMerge create the list or compound they add to.
+//
+// If an operation fails, root is left as it was. The operations are tried on
+// a copy of root first, so that the maps and slices in it are still the ones
+// changed when they succeed.
+func Apply(root map[string]interface{}, ops ...Operation) (err error) {
+	defer recoverError(&err)
+
+	applyAll(deepCopy(root).(map[string]interface{}), ops)
+	applyAll(root, ops)
+	return nil
+}
+
+func applyAll(root map[string]interface{}, ops []Operation) {
+	for i, op := range ops {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panic(annotate(r, "\n\t\tin operation %d (%s %s)", i, op.Op, op.Path))
+				}
+			}()
+			apply(root, op)
+		}()
+	}
+}
+
+func apply(root map[string]interface{}, op Operation) {
+	var value interface{}
+	if op.Op != OpRemove {
+		value = toTree(op.Value)
+	}
+
+	var matches []Match
+	switch op.Op {
+	case OpSet:
+		createIn(root, root, op.Path.nodes, nil, value, &matches)
+	case OpRemove:
+		matches = op.Path.Find(root)
+	case OpAppend, OpInsert:
+		createIn(root, root, op.Path.nodes, nil, []interface{}{}, &matches)
+	case OpMerge:
+		if _, ok := value.(map[string]interface{}); !ok {
+			panic(fmt.Errorf("nbt: Can't merge a %T into a compound", value))
+		}
+		createIn(root, root, op.Path.nodes, nil, map[string]interface{}{}, &matches)
+	default:
+		panic(fmt.Errorf("nbt: Unknown operation %s", op.Op))
+	}
+	if len(matches) == 0 {
+		panic(fmt.Errorf("nbt: Nothing matches %s", op.Path))
+	}
+
+	switch op.Op {
+	case OpSet:
+		for _, m := range matches {
+			setAt(root, m.elems, deepCopy(value))
+		}
+
+	case OpRemove:
+		// Later elements of a list go first, so the indexes of earlier ones
+		// still hold.
+		for i := len(matches) - 1; i >= 0; i-- {
+			removeAt(root, matches[i].elems)
+		}
+
+	case OpAppend, OpInsert:
+		for _, m := range matches {
+			index := -1
+			if op.Op == OpInsert {
+				index = op.Index
+			}
+			setAt(root, m.elems, insert(m.Path, m.Value, deepCopy(value), index))
+		}
+
+	case OpMerge:
+		for _, m := range matches {
+			compound, ok := m.Value.(map[string]interface{})
+			if !ok {
+				panic(fmt.Errorf("nbt: Can't merge into %s, which is not a compound", m.Path))
+			}
+			Merge(compound, value.(map[string]interface{}))
+		}
+	}
+}
+
+// Like findIn, but creates missing compound entries as Minecraft does: a
+// missing entry at the end of the path is set to leaf, and missing entries
+// before that are created as whatever the next step of the path needs. A
+// [{filter}] step that matches nothing in a list adds a copy of the filter.
+func createIn(root, v interface{}, nodes []pathNode, at []pathElem, leaf interface{}, matches *[]Match) {
+	if len(nodes) == 0 {
+		elems := append([]pathElem(nil), at...)
+		*matches = append(*matches, Match{formatPath(elems), v, elems})
+		return
+	}
+
+	node := nodes[0]
+	switch node.kind {
+	case rootFilterNode:
+		if matchesFilter(node.filter, v) {
+			createIn(root, v, nodes[1:], at, leaf, matches)
+		}
+
+	case childNode:
+		compound, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		child, ok := compound[node.name]
+		if !ok {
+			switch {
+			case node.filter != nil:
+				child = deepCopy(node.filter)
+			case len(nodes) == 1:
+				child = deepCopy(leaf)
+			case nodes[1].kind == childNode:
+				child = map[string]interface{}{}
+			default:
+				child = []interface{}{}
+			}
+			compound[node.name] = child
+		}
+		if node.filter == nil || matchesFilter(node.filter, child) {
+			createIn(root, child, nodes[1:], append(at, pathElem{node.name, -1}), leaf, matches)
+		}
+
+	default:
+		found := len(*matches)
+		findIn(v, nodes[:1], at, matches)
+		added := (*matches)[found:]
+		*matches = (*matches)[:found]
+
+		if list, ok := v.([]interface{}); ok && node.kind == filterNode && len(added) == 0 {
+			el := deepCopy(node.filter)
+			setAt(root, at, append(list, el))
+			added = []Match{{elems: append(append([]pathElem(nil), at...), pathElem{index: len(list)}), Value: el}}
+		}
+		for _, m := range added {
+			createIn(root, m.Value, nodes[1:], m.elems, leaf, matches)
+		}
+	}
+}
+
+// Returns the value at a path in a tree.
+func valueAt(root interface{}, elems []pathElem) interface{} {
+	v := root
+	for _, el := range elems {
+		if el.index < 0 {
+			v = v.(map[string]interface{})[el.name]
+		} else {
+			v = listIndex(v, el.index)
+		}
+	}
+	return v
+}
+
+// Replaces the value at a path in a tree. List elements must have the same
+// tag as the rest of the list.
+func setAt(root interface{}, elems []pathElem, v interface{}) {
+	if len(elems) == 0 {
+		panic(fmt.Errorf("nbt: Can't replace the root compound"))
+	}
+
+	parent := valueAt(root, elems[:len(elems)-1])
+	el := elems[len(elems)-1]
+	if el.index < 0 {
+		parent.(map[string]interface{})[el.name] = v
+		return
+	}
+
+	i := el.index
+	switch list := parent.(type) {
+	case []interface{}:
+		for j, other := range list {
+			if j != i {
+				checkElementTag(formatPath(elems), other, v)
+				break
+			}
+		}
+		list[i] = v
+	case []byte:
+		checkElementTag(formatPath(elems), int8(list[i]), v)
+		list[i] = byte(v.(int8))
+	case []int32:
+		checkElementTag(formatPath(elems), list[i], v)
+		list[i] = v.(int32)
+	case []int64:
+		checkElementTag(formatPath(elems), list[i], v)
+		list[i] = v.(int64)
+	}
+}
+
+func checkElementTag(path string, element, v interface{}) {
+	want, _ := dynamicTag(element)
+	got, _ := dynamicTag(v)
+	if got != want {
+		panic(fmt.Errorf("nbt: Can't put a %s in %s, which holds %s elements", got, path, want))
+	}
+}
+
+// Removes the tag at a path from a tree.
+func removeAt(root interface{}, elems []pathElem) {
+	if len(elems) == 0 {
+		panic(fmt.Errorf("nbt: Can't remove the root compound"))
+	}
+
+	parentPath := elems[:len(elems)-1]
+	parent := valueAt(root, parentPath)
+	el := elems[len(elems)-1]
+	if el.index < 0 {
+		delete(parent.(map[string]interface{}), el.name)
+		return
+	}
+
+	list := reflect.ValueOf(parent)
+	shorter := reflect.MakeSlice(list.Type(), 0, list.Len()-1)
+	shorter = reflect.AppendSlice(shorter, list.Slice(0, el.index))
+	shorter = reflect.AppendSlice(shorter, list.Slice(el.index+1, list.Len()))
+	setAt(root, parentPath, shorter.Interface())
+}
+
+// Returns a copy of a list or array with v inserted before index, which counts
+// from the end if it is negative.
+func insert(path string, list, v interface{}, index int) interface{} {
+	l := reflect.ValueOf(list)
+	switch list.(type) {
+	case []interface{}, []byte, []int32, []int64:
+	default:
+		panic(fmt.Errorf("nbt: Can't insert into %s, which is not a list or array", path))
+	}
+
+	n := l.Len()
+	if index < 0 {
+		index += n + 1
+	}
+	if index < 0 || index > n {
+		panic(fmt.Errorf("nbt: Index %d is out of range for %s, which has %d elements", index, path, n))
+	}
+
+	var elem reflect.Value
+	switch list.(type) {
+	case []interface{}:
+		if n > 0 {
+			checkElementTag(path, l.Index(0).Interface(), v)
+		}
+		elem = reflect.ValueOf(&v).Elem()
+	case []byte:
+		checkElementTag(path, int8(0), v)
+		elem = reflect.ValueOf(byte(v.(int8)))
+	default:
+		checkElementTag(path, reflect.Zero(l.Type().Elem()).Interface(), v)
+		elem = reflect.ValueOf(v)
+	}
+
+	c := reflect.MakeSlice(l.Type(), 0, n+1)
+	c = reflect.AppendSlice(c, l.Slice(0, index))
+	c = reflect.Append(c, elem)
+	c = reflect.AppendSlice(c, l.Slice(index, n))
+	return c.Interface()
+}
@@ -0,0 +1,125 @@
+package nbt
+
+import "unicode/utf8"
+
+// Java writes NBT strings with DataOutput.writeUTF, which uses "modified
+// UTF-8": NUL is encoded as the two bytes 0xC0 0x80 and characters outside the
+// Basic Multilingual Plane are encoded as a UTF-16 surrogate pair, each half
+// taking three bytes. Everything else is identical to UTF-8.
+
+// Reports whether s is spelled the same in modified UTF-8 as in UTF-8 because
+// it is plain ASCII without NUL.
+func plainASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of bytes appendMUTF8 will use for s.
+func mutf8Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += mutf8RuneLen(r)
+	}
+	return n
+}
+
+func mutf8RuneLen(r rune) int {
+	switch {
+	case r == 0:
+		return 2
+	case r < utf8.RuneSelf:
+		return 1
+	case r < 0x800:
+		return 2
+	case r < 0x10000:
+		return 3
+	}
+	return 6
+}
+
+// Returns the longest prefix of s that takes at most n bytes in modified UTF-8.
+func truncateMUTF8(s string, n int) string {
+	size := 0
+	for i, r := range s {
+		size += mutf8RuneLen(r)
+		if size > n {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func appendMUTF8(dst []byte, s string) []byte {
+	for _, r := range s {
+		switch {
+		case r == 0:
+			dst = append(dst, 0xc0, 0x80)
+		case r < utf8.RuneSelf:
+			dst = append(dst, byte(r))
+		case r < 0x10000:
+			dst = utf8.AppendRune(dst, r)
+		default:
+			r -= 0x10000
+			dst = appendSurrogate(dst, 0xd800+r>>10)
+			dst = appendSurrogate(dst, 0xdc00+r&0x3ff)
+		}
+	}
+	return dst
+}
+
+// utf8.AppendRune refuses to encode surrogate halves, so do it by hand.
+func appendSurrogate(dst []byte, r rune) []byte {
+	return append(dst, 0xe0|byte(r>>12), 0x80|byte(r>>6)&0x3f, 0x80|byte(r)&0x3f)
+}
+
+// Decodes a modified UTF-8 string. Malformed sequences and unpaired surrogates
+// are replaced with U+FFFD, the same way Go treats invalid UTF-8.
+func decodeMUTF8(b []byte) string {
+	if utf8.Valid(b) {
+		// Modified UTF-8 that is also valid UTF-8 means the same thing in both.
+		return string(b)
+	}
+
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		r, n := decodeMUTF8Unit(b[i:])
+		i += n
+
+		if r >= 0xd800 && r < 0xdc00 {
+			if low, m := decodeMUTF8Unit(b[i:]); low >= 0xdc00 && low < 0xe000 {
+				r = 0x10000 + (r-0xd800)<<10 + (low - 0xdc00)
+				i += m
+			}
+		}
+
+		out = utf8.AppendRune(out, r)
+	}
+	return string(out)
+}
+
+// Decodes one, two, or three byte group, which may be a surrogate half.
+func decodeMUTF8Unit(b []byte) (rune, int) {
+	if len(b) == 0 {
+		return utf8.RuneError, 0
+	}
+
+	c := b[0]
+	switch {
+	case c < 0x80:
+		return rune(c), 1
+	case c&0xe0 == 0xc0 && len(b) >= 2 && b[1]&0xc0 == 0x80:
+		return rune(c&0x1f)<<6 | rune(b[1]&0x3f), 2
+	case c&0xf0 == 0xe0 && len(b) >= 3 && b[1]&0xc0 == 0x80 && b[2]&0xc0 == 0x80:
+		return rune(c&0x0f)<<12 | rune(b[1]&0x3f)<<6 | rune(b[2]&0x3f), 3
+	case c&0xf8 == 0xf0:
+		// Not modified UTF-8, but some writers emit plain UTF-8 anyway.
+		if r, n := utf8.DecodeRune(b); r != utf8.RuneError {
+			return r, n
+		}
+	}
+	return utf8.RuneError, 1
+}
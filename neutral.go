@@ -0,0 +1,361 @@
+package nbt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// The neutral form of a document is made of the values that YAML and TOML
+// libraries read and write, so that documents can be kept in those formats:
+// compounds are maps, lists are slices, and TAG_String, TAG_Int and TAG_Double
+// are plain strings, integers and floats. Every other tag is given as a map
+// with a single entry, whose key names the tag:
+//
+//	Health: {short: 20}
+//	Pos: [{double: 0}, 64.5, {double: 0}]
+//	Inventory:
+//	  - {Slot: {byte: 0}, id: "minecraft:stone", Count: {byte: 1}}
+//	Data: {byteArray: [1, 2, 3]}
+//
+// The type names are the ones typed JSON uses. Doubles are only given a hint
+// when they are whole numbers, since many encoders write those without a
+// decimal point. A compound that would look like a hint is itself wrapped,
+// as in {compound: {short: 20}}.
+
+// Converts a tree of values like the ones Unmarshal produces when decoding
+// into an interface{} to the neutral form. Other values are converted as for
+// Diff.
+func ToNeutral(v interface{}) (neutral interface{}, err error) {
+	defer recoverError(&err)
+	return toNeutral(toTree(v)), nil
+}
+
+func toNeutral(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int8:
+		return map[string]interface{}{"byte": v}
+	case int16:
+		return map[string]interface{}{"short": v}
+	case int32, string:
+		return v
+	case int64:
+		return map[string]interface{}{"long": v}
+	case float32:
+		return map[string]interface{}{"float": v}
+	case float64:
+		if v == math.Trunc(v) {
+			return map[string]interface{}{"double": v}
+		}
+		return v
+	case []byte:
+		bytes := make([]int8, len(v))
+		for i, b := range v {
+			bytes[i] = int8(b)
+		}
+		return map[string]interface{}{"byteArray": bytes}
+	case []int32:
+		return map[string]interface{}{"intArray": v}
+	case []int64:
+		return map[string]interface{}{"longArray": v}
+
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, el := range v {
+			list[i] = toNeutral(el)
+		}
+		return list
+
+	case map[string]interface{}:
+		compound := make(map[string]interface{}, len(v))
+		for name, el := range v {
+			compound[name] = toNeutral(el)
+		}
+		if isHint(reflect.ValueOf(compound)) {
+			return map[string]interface{}{"compound": compound}
+		}
+		return compound
+	}
+	panic(fmt.Errorf("nbt: Unhandled type: %T (%v)", v, v))
+}
+
+// Converts a value in the neutral form back to a tree of values like the ones
+// Unmarshal produces when decoding into an interface{}. It accepts what YAML,
+// TOML and JSON libraries commonly decode to: maps with string or interface{}
+// keys, slices of any type, integers and floats of any width, json.Number and
+// booleans, which become TAG_Byte. Whole numbers without a hint that don't fit
+// in a TAG_Int become TAG_Long.
+func FromNeutral(v interface{}) (tree interface{}, err error) {
+	defer recoverError(&err)
+	return fromNeutral(reflect.ValueOf(v)), nil
+}
+
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// Strips interfaces and pointers.
+func neutralValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		panic(fmt.Errorf("nbt: Unhandled type: nil"))
+	}
+	return v
+}
+
+// Reports whether a map is a type hint.
+func isHint(v reflect.Value) bool {
+	if v.Len() != 1 {
+		return false
+	}
+	key := neutralValue(v.MapKeys()[0])
+	if key.Kind() != reflect.String {
+		return false
+	}
+	tag, ok := tagNamed(key.String())
+	return ok && tag != TagEnd
+}
+
+func fromNeutral(v reflect.Value) interface{} {
+	v = neutralValue(v)
+
+	switch v.Kind() {
+	case reflect.Map:
+		if isHint(v) {
+			key := v.MapKeys()[0]
+			tag, _ := tagNamed(neutralValue(key).String())
+			defer func() {
+				if r := recover(); r != nil {
+					panic(annotate(r, "\n\t\tin %s hint", tag))
+				}
+			}()
+			return fromHint(tag, v.MapIndex(key))
+		}
+		return neutralCompound(v)
+
+	case reflect.Slice, reflect.Array:
+		return neutralList(v)
+
+	case reflect.String:
+		if v.Type() == jsonNumberType {
+			if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+				return smallestInt(n)
+			}
+			return neutralFloat(v, 64)
+		}
+		return v.String()
+
+	case reflect.Bool:
+		if v.Bool() {
+			return int8(1)
+		}
+		return int8(0)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return smallestInt(neutralInt(v, 64))
+
+	case reflect.Float32:
+		return float32(v.Float())
+	case reflect.Float64:
+		return v.Float()
+	}
+	panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", v.Type(), v.Interface()))
+}
+
+func smallestInt(n int64) interface{} {
+	if n >= math.MinInt32 && n <= math.MaxInt32 {
+		return int32(n)
+	}
+	return n
+}
+
+func fromHint(tag Tag, v reflect.Value) interface{} {
+	switch tag {
+	case TagByte:
+		return int8(neutralInt(v, 8))
+	case TagShort:
+		return int16(neutralInt(v, 16))
+	case TagInt:
+		return int32(neutralInt(v, 32))
+	case TagLong:
+		return neutralInt(v, 64)
+	case TagFloat:
+		return float32(neutralFloat(v, 32))
+	case TagDouble:
+		return neutralFloat(v, 64)
+	case TagString:
+		v = neutralValue(v)
+		if v.Kind() != reflect.String || v.Type() == jsonNumberType {
+			panic(fmt.Errorf("nbt: Expected a string, but found %v", v.Interface()))
+		}
+		return v.String()
+
+	case TagByteArray, TagIntArray, TagLongArray:
+		v = neutralValue(v)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			panic(fmt.Errorf("nbt: Expected a list of numbers, but found %v", v.Interface()))
+		}
+		switch tag {
+		case TagByteArray:
+			array := make([]byte, v.Len())
+			for i := range array {
+				array[i] = byte(neutralInt(v.Index(i), 8))
+			}
+			return array
+		case TagIntArray:
+			array := make([]int32, v.Len())
+			for i := range array {
+				array[i] = int32(neutralInt(v.Index(i), 32))
+			}
+			return array
+		default:
+			array := make([]int64, v.Len())
+			for i := range array {
+				array[i] = neutralInt(v.Index(i), 64)
+			}
+			return array
+		}
+
+	case TagList:
+		v = neutralValue(v)
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			panic(fmt.Errorf("nbt: Expected a list, but found %v", v.Interface()))
+		}
+		return neutralList(v)
+
+	case TagCompound:
+		v = neutralValue(v)
+		if v.Kind() != reflect.Map {
+			panic(fmt.Errorf("nbt: Expected a map, but found %v", v.Interface()))
+		}
+		return neutralCompound(v)
+	}
+	panic(fmt.Errorf("nbt: Unhandled tag %s", tag))
+}
+
+// Returns an integer that must fit in the given number of bits. Floats are
+// accepted if they are whole numbers.
+func neutralInt(v reflect.Value, bits uint) int64 {
+	v = neutralValue(v)
+
+	var n int64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			panic(fmt.Errorf("nbt: %v does not fit in %d bits", v.Uint(), bits))
+		}
+		n = int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			panic(fmt.Errorf("nbt: Expected a whole number, but found %v", f))
+		}
+		n = int64(f)
+	default:
+		if v.Type() != jsonNumberType {
+			panic(fmt.Errorf("nbt: Expected a number, but found %v", v.Interface()))
+		}
+		var err error
+		n, err = strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("nbt: Expected a whole number, but found %v", v.String()))
+		}
+	}
+
+	if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+		panic(fmt.Errorf("nbt: %d does not fit in %d bits", n, bits))
+	}
+	return n
+}
+
+func neutralFloat(v reflect.Value, bits int) float64 {
+	v = neutralValue(v)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	if v.Type() == jsonNumberType {
+		if f, err := strconv.ParseFloat(v.String(), bits); err == nil {
+			return f
+		}
+	}
+	panic(fmt.Errorf("nbt: Expected a number, but found %v", v.Interface()))
+}
+
+func neutralList(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		// []byte is how most libraries give binary data.
+		return append([]byte(nil), v.Bytes()...)
+	}
+
+	list := make([]interface{}, v.Len())
+	var tag Tag
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			panic(annotate(r, "\n\t\tat list index %d", i))
+		}
+	}()
+	for i = range list {
+		list[i] = fromNeutral(v.Index(i))
+		t, _ := dynamicTag(list[i])
+		switch {
+		case i == 0 || t == tag:
+			tag = t
+		case isPlainNumber(t) && isPlainNumber(tag):
+			// Numbers are widened to fit the rest of the list.
+			if t > tag {
+				tag = t
+			}
+		default:
+			panic(fmt.Errorf("nbt: List elements must all have the same tag, but element 0 is %s and element %d is %s", tag, i, t))
+		}
+	}
+
+	for i = range list {
+		switch n := list[i].(type) {
+		case int32:
+			if tag == TagLong {
+				list[i] = int64(n)
+			} else if tag == TagDouble {
+				list[i] = float64(n)
+			}
+		case int64:
+			if tag == TagDouble {
+				list[i] = float64(n)
+			}
+		}
+	}
+	return list
+}
+
+func neutralCompound(v reflect.Value) map[string]interface{} {
+	compound := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := neutralValue(iter.Key())
+		if key.Kind() != reflect.String {
+			panic(fmt.Errorf("nbt: Map keys must be strings, but found %v", key.Interface()))
+		}
+		name := key.String()
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panic(annotate(r, "\n\t\tat struct field %#v", name))
+				}
+			}()
+			compound[name] = fromNeutral(iter.Value())
+		}()
+	}
+	return compound
+}
@@ -0,0 +1,208 @@
+package nbt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Copies an NBT document from in to out, replacing every tag the path selects
+// with v, and returns the number of tags replaced. Only the parts of the
+// document that the path needs are decoded; everything else is copied byte for
+// byte. The output is compressed the same way as the input.
+//
+// A compound entry may be replaced by a value of any tag, but list and array
+// elements may only be replaced by values with the same tag as the list.
+func Patch(compression Compression, in io.Reader, out io.Writer, path *Path, v interface{}) (int, error) {
+	return new(Decoder).Patch(compression, in, out, path, v)
+}
+
+// Like Patch, but for an uncompressed document in memory. It returns the
+// patched copy of data.
+func PatchBytes(data []byte, path *Path, v interface{}) ([]byte, int, error) {
+	return new(Decoder).PatchBytes(data, path, v)
+}
+
+func (dec *Decoder) PatchBytes(data []byte, path *Path, v interface{}) ([]byte, int, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	n, err := dec.Patch(Uncompressed, bytes.NewReader(data), &buf, path, v)
+	if err != nil {
+		return nil, n, err
+	}
+	return buf.Bytes(), n, nil
+}
+
+func (dec *Decoder) Patch(compression Compression, in io.Reader, out io.Writer, path *Path, v interface{}) (n int, err error) {
+	defer recoverError(&err)
+
+	if out == nil {
+		panic(fmt.Errorf("nbt: Output stream is nil"))
+	}
+
+	// Filters compare against the generic form of a tag, so registered types
+	// must not be decoded.
+	plain := *dec
+	plain.Registry = nil
+	d := newDecodeState(&plain).init(compression, in)
+
+	p := &patcher{out: out}
+	switch compression {
+	case GZip:
+		z := gzip.NewWriter(out)
+		defer closeCompressor(z, &err)
+		p.out = z
+	case ZLib:
+		z := zlib.NewWriter(out)
+		defer closeCompressor(z, &err)
+		p.out = z
+	}
+
+	// Errors in v give paths from v itself, as if it were the root.
+	e := encodeState{Encoder: &Encoder{Dialect: dec.Dialect}, order: d.order, path: []pathElem{{"", -1}}}
+	value := indirect(reflect.ValueOf(v))
+	if !value.IsValid() {
+		panic(fmt.Errorf("nbt: Unhandled type: nil"))
+	}
+	tag, ok := valueTag(value, dynamicOptions(value))
+	if !ok {
+		panic(fmt.Errorf("nbt: Unhandled type: %v (%v)", value.Type(), value.Interface()))
+	}
+	e.writePayload(tag, value)
+	p.tag, p.payload = tag, e.buf
+
+	d.recorded = make([]byte, 0, patchBufferSize)
+	_, tag = d.readTag()
+	p.walk(d, tag, path.nodes, 0, nil)
+
+	// Anything after the document is copied as is.
+	p.flush(d, 0)
+	if _, err := io.Copy(p.out, d.in); err != nil {
+		panic(err)
+	}
+	return p.count, nil
+}
+
+func closeCompressor(w io.Closer, err *error) {
+	if cerr := w.Close(); *err == nil {
+		*err = cerr
+	}
+}
+
+// Output is written once this much of it has been buffered.
+const patchBufferSize = 64 << 10
+
+type patcher struct {
+	out     io.Writer
+	tag     Tag    // The replacement's tag.
+	payload []byte // The replacement's encoded payload.
+	count   int
+}
+
+// Writes out what has been read so far, if there is at least min bytes of it.
+// The input read by d is recorded in d.recorded, which is what is written.
+func (p *patcher) flush(d *decodeState, min int) {
+	if len(d.recorded) < min || len(d.recorded) == 0 {
+		return
+	}
+	if _, err := p.out.Write(d.recorded); err != nil {
+		panic(err)
+	}
+	d.recorded = d.recorded[:0]
+}
+
+// Like find, but copies the payload to the output as it goes, replacing the
+// tags at the end of the path. header is the position in d.recorded of the
+// byte that holds the tag, or -1 for list and array elements.
+func (p *patcher) walk(d *decodeState, tag Tag, nodes []pathNode, header int, at []pathElem) {
+	if len(nodes) == 0 {
+		if header < 0 && tag != p.tag {
+			panic(fmt.Errorf("nbt: Can't replace a %s element at %s with a %s", tag, formatPath(at), p.tag))
+		}
+		recorded := d.recorded
+		d.recorded = nil
+		d.skip(tag)
+		d.recorded = recorded
+		if header >= 0 {
+			d.recorded[header] = byte(p.tag)
+		}
+		d.recorded = append(d.recorded, p.payload...)
+		p.count++
+		return
+	}
+
+	node := nodes[0]
+	switch {
+	case node.kind == rootFilterNode:
+		p.filtered(d, tag, node.filter, nodes[1:], header, at)
+
+	case node.kind == childNode && tag == TagCompound:
+		for {
+			p.flush(d, patchBufferSize)
+			mark := len(d.recorded)
+			name, tag := d.readTag()
+			if tag == TagEnd {
+				return
+			}
+			if name != node.name {
+				d.skip(tag)
+				continue
+			}
+			elems := append(at, pathElem{name, -1})
+			if node.filter == nil {
+				p.walk(d, tag, nodes[1:], mark, elems)
+			} else {
+				p.filtered(d, tag, node.filter, nodes[1:], mark, elems)
+			}
+		}
+
+	case node.kind != childNode && (tag == TagList || tag == TagByteArray || tag == TagIntArray || tag == TagLongArray):
+		var inner Tag
+		switch tag {
+		case TagList:
+			inner = Tag(d.readU8())
+		case TagByteArray:
+			inner = TagByte
+		case TagIntArray:
+			inner = TagInt
+		case TagLongArray:
+			inner = TagLong
+		}
+		length := d.readLen()
+		for i := 0; i < length; i++ {
+			p.flush(d, patchBufferSize)
+			if node.kind == indexNode && i != node.index && i != length+node.index {
+				d.skip(inner)
+				continue
+			}
+			elems := append(at, pathElem{index: i})
+			if node.kind == filterNode {
+				p.filtered(d, inner, node.filter, nodes[1:], -1, elems)
+			} else {
+				p.walk(d, inner, nodes[1:], -1, elems)
+			}
+		}
+
+	default:
+		d.skip(tag)
+	}
+}
+
+// Decodes a payload to check it against a filter, and if it matches, walks
+// it again from the copy that was recorded while decoding it.
+func (p *patcher) filtered(d *decodeState, tag Tag, filter map[string]interface{}, nodes []pathNode, header int, at []pathElem) {
+	mark := len(d.recorded)
+	if !matchesFilter(filter, d.decodeAny(tag)) {
+		return
+	}
+
+	payload := append([]byte(nil), d.recorded[mark:]...)
+	sub := newDecodeState(d.Decoder)
+	sub.in = bytes.NewReader(payload)
+	sub.recorded = d.recorded[:mark]
+	p.walk(sub, tag, nodes, header, at)
+	d.recorded = sub.recorded
+}
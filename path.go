@@ -0,0 +1,394 @@
+package nbt
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// One step of the path to a tag: a compound entry or a list element.
+type pathElem struct {
+	name  string
+	index int // -1 for compound entries.
+}
+
+// Formats a path the way Minecraft's NBT paths are written, e.g.
+// Inventory[3].tag.display.Name
+func formatPath(path []pathElem) string {
+	var b strings.Builder
+	for _, el := range path {
+		if el.index >= 0 {
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(el.index))
+			b.WriteByte(']')
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(quotePathName(el.name))
+	}
+	return b.String()
+}
+
+// Quotes a compound key if it can't be written bare in a path.
+func quotePathName(name string) string {
+	if name == "" {
+		return `""`
+	}
+	for _, c := range name {
+		if !isBareChar(c) {
+			return quote(name)
+		}
+	}
+	return name
+}
+
+// Quotes a string, escaping only quotes and backslashes like Minecraft does.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Reports whether c may appear in a key without quotes.
+func isBareChar(c rune) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' ||
+		c == '_' || c == '-' || c == '+'
+}
+
+// A Path selects tags inside an NBT document, using the same syntax as the
+// paths in Minecraft's /data command:
+//
+//	Pos[0]                           the first element of the Pos list
+//	Inventory[]                      every element of Inventory
+//	Inventory[-1]                    the last element
+//	Inventory[{Slot:3b}].tag         the tag compound of items in slot 3
+//	Item{id:"minecraft:stone"}.Count Count, if Item has the given entries
+//	{OnGround:1b}.Health             Health, if the root has OnGround:1b
+//	"quoted key".x                   keys with unusual characters in quotes
+//
+// A filter compound matches a compound that has all of its entries with the
+// same tags and values; lists in a filter match lists that contain a match
+// for each of their elements.
+type Path struct {
+	text  string
+	nodes []pathNode
+}
+
+type pathNodeKind byte
+
+const (
+	rootFilterNode pathNodeKind = iota // {filter}
+	childNode                          // name or name{filter}
+	indexNode                          // [index]
+	allNode                            // []
+	filterNode                         // [{filter}]
+)
+
+type pathNode struct {
+	kind   pathNodeKind
+	name   string
+	index  int
+	filter map[string]interface{} // nil if there is none.
+}
+
+// A Match is a tag selected by a Path.
+type Match struct {
+	Path  string      // The exact path to the tag, e.g. Inventory[2].tag
+	Value interface{} // The tag's value, decoded as for an interface{}.
+
+	elems []pathElem
+}
+
+func ParsePath(s string) (path *Path, err error) {
+	defer recoverError(&err)
+
+	p := &snbtParser{s: s}
+	path = &Path{text: s}
+	if p.peekRaw() == '{' {
+		path.nodes = append(path.nodes, pathNode{kind: rootFilterNode, filter: p.compound()})
+		if p.peekRaw() != 0 {
+			p.expect('.')
+		}
+	}
+
+	for p.pos < len(p.s) {
+		if p.peekRaw() == '[' {
+			path.nodes = append(path.nodes, p.pathIndex())
+		} else {
+			node := pathNode{kind: childNode}
+			if c := p.peekRaw(); c == '"' || c == '\'' {
+				node.name = p.quoted()
+			} else {
+				start := p.pos
+				for p.pos < len(p.s) && strings.IndexByte(" \"'[]{}.", p.s[p.pos]) < 0 {
+					p.pos++
+				}
+				node.name = p.s[start:p.pos]
+				if node.name == "" {
+					p.fail("Expected a key")
+				}
+			}
+			if p.peekRaw() == '{' {
+				node.filter = p.compound()
+			}
+			path.nodes = append(path.nodes, node)
+		}
+
+		switch p.peekRaw() {
+		case '.':
+			p.pos++
+			if c := p.peekRaw(); c == 0 || c == '[' || c == '.' {
+				p.fail("Expected a key after '.'")
+			}
+		case '[', 0:
+		default:
+			p.fail("Unexpected %q", p.s[p.pos])
+		}
+	}
+
+	if len(path.nodes) == 0 {
+		p.fail("Empty path")
+	}
+	return
+}
+
+// Like peek, but doesn't skip spaces; they are not allowed between nodes.
+func (p *snbtParser) peekRaw() byte {
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *snbtParser) pathIndex() pathNode {
+	p.expect('[')
+	var node pathNode
+	switch p.peek() {
+	case ']':
+		node.kind = allNode
+	case '{':
+		node.kind = filterNode
+		node.filter = p.compound()
+	default:
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '-' || p.s[p.pos] >= '0' && p.s[p.pos] <= '9') {
+			p.pos++
+		}
+		index, err := strconv.Atoi(p.s[start:p.pos])
+		if err != nil {
+			p.pos = start
+			p.fail("Expected an index")
+		}
+		node.kind = indexNode
+		node.index = index
+	}
+	p.expect(']')
+	return node
+}
+
+func (path *Path) String() string {
+	return path.text
+}
+
+// Finds the tags the path selects in a tree of values like the ones Unmarshal
+// produces when decoding into an interface{}.
+func (path *Path) Find(root interface{}) []Match {
+	var matches []Match
+	findIn(root, path.nodes, nil, &matches)
+	return matches
+}
+
+func findIn(v interface{}, nodes []pathNode, at []pathElem, matches *[]Match) {
+	if len(nodes) == 0 {
+		elems := append([]pathElem(nil), at...)
+		*matches = append(*matches, Match{formatPath(elems), v, elems})
+		return
+	}
+
+	node := nodes[0]
+	switch node.kind {
+	case rootFilterNode:
+		if matchesFilter(node.filter, v) {
+			findIn(v, nodes[1:], at, matches)
+		}
+
+	case childNode:
+		compound, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		child, ok := compound[node.name]
+		if ok && (node.filter == nil || matchesFilter(node.filter, child)) {
+			findIn(child, nodes[1:], append(at, pathElem{node.name, -1}), matches)
+		}
+
+	default:
+		length := listLen(v)
+		for i := 0; i < length; i++ {
+			if node.kind == indexNode && i != node.index && i != length+node.index {
+				continue
+			}
+			el := listIndex(v, i)
+			if node.kind == filterNode && !matchesFilter(node.filter, el) {
+				continue
+			}
+			findIn(el, nodes[1:], append(at, pathElem{index: i}), matches)
+		}
+	}
+}
+
+// Returns the length of a list or array value, or 0 for anything else.
+func listLen(v interface{}) int {
+	switch v := v.(type) {
+	case []interface{}:
+		return len(v)
+	case []byte:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []int64:
+		return len(v)
+	}
+	return 0
+}
+
+// Returns an element of a list or array value. Byte array elements are given
+// as int8, like TAG_Byte values.
+func listIndex(v interface{}, i int) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v[i]
+	case []byte:
+		return int8(v[i])
+	case []int32:
+		return v[i]
+	case []int64:
+		return v[i]
+	}
+	return nil
+}
+
+// Reports whether v matches a filter, the way Minecraft compares NBT in paths
+// and selectors: compounds need only contain the filter's entries, lists need
+// only contain a match for each of the filter's elements (an empty filter list
+// matches only an empty list), and everything else must be equal.
+func matchesFilter(filter, v interface{}) bool {
+	switch filter := filter.(type) {
+	case map[string]interface{}:
+		compound, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for name, want := range filter {
+			got, ok := compound[name]
+			if !ok || !matchesFilter(want, got) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		list, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+		if len(filter) == 0 {
+			return len(list) == 0
+		}
+	outer:
+		for _, want := range filter {
+			for _, got := range list {
+				if matchesFilter(want, got) {
+					continue outer
+				}
+			}
+			return false
+		}
+		return true
+	}
+	return reflect.DeepEqual(filter, v)
+}
+
+// Finds the tags the path selects in an encoded NBT document, decoding only
+// the parts of it that the path needs.
+func Query(compression Compression, in io.Reader, path *Path) ([]Match, error) {
+	return new(Decoder).Query(compression, in, path)
+}
+
+func (dec *Decoder) Query(compression Compression, in io.Reader, path *Path) (matches []Match, err error) {
+	defer recoverError(&err)
+	d := newDecodeState(dec).init(compression, in)
+	_, tag := d.readTag()
+	d.find(tag, path.nodes, nil, &matches)
+	return
+}
+
+// Returns a payload decoded as for an interface{}.
+func (d *decodeState) decodeAny(tag Tag) interface{} {
+	v := d.allocate(tag)
+	d.readValue(tag, v)
+	return v.Interface()
+}
+
+// Like findIn, but reads the value from the input. Payloads that can't contain
+// a match are skipped; anything a filter applies to is decoded and handed to
+// findIn.
+func (d *decodeState) find(tag Tag, nodes []pathNode, at []pathElem, matches *[]Match) {
+	if len(nodes) == 0 || nodes[0].kind == rootFilterNode {
+		findIn(d.decodeAny(tag), nodes, at, matches)
+		return
+	}
+
+	node := nodes[0]
+	switch {
+	case node.kind == childNode && tag == TagCompound:
+		for {
+			name, tag := d.readTag()
+			if tag == TagEnd {
+				return
+			}
+			if name != node.name {
+				d.skip(tag)
+				continue
+			}
+			elems := append(at, pathElem{name, -1})
+			if node.filter == nil {
+				d.find(tag, nodes[1:], elems, matches)
+			} else if v := d.decodeAny(tag); matchesFilter(node.filter, v) {
+				findIn(v, nodes[1:], elems, matches)
+			}
+		}
+
+	case node.kind != childNode && tag == TagList:
+		inner := Tag(d.readU8())
+		length := d.readLen()
+		for i := 0; i < length; i++ {
+			if node.kind == indexNode && i != node.index && i != length+node.index {
+				d.skip(inner)
+				continue
+			}
+			elems := append(at, pathElem{index: i})
+			if node.kind != filterNode {
+				d.find(inner, nodes[1:], elems, matches)
+			} else if v := d.decodeAny(inner); matchesFilter(node.filter, v) {
+				findIn(v, nodes[1:], elems, matches)
+			}
+		}
+
+	case node.kind != childNode && (tag == TagByteArray || tag == TagIntArray || tag == TagLongArray):
+		findIn(d.decodeAny(tag), nodes, at, matches)
+
+	default:
+		d.skip(tag)
+	}
+}
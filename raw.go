@@ -0,0 +1,133 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A RawTag holds a tag that has not been decoded: its type and the exact bytes
+// of its payload, in the dialect they were read in. Decoding into a RawTag
+// captures the bytes without interpreting them and encoding one writes them
+// back verbatim, so it can be used like json.RawMessage to put off decoding
+// part of a document or to pass it through untouched. For example, decoding an
+// entity into a map[string]RawTag gives access to its "id" without decoding
+// anything else.
+type RawTag struct {
+	Tag     Tag
+	Payload []byte
+	Dialect Dialect
+}
+
+// Decodes the payload into v, which must be a pointer, as Unmarshal would.
+func (raw RawTag) Unmarshal(v interface{}) (err error) {
+	defer recoverError(&err)
+	d := newDecodeState(&Decoder{Dialect: raw.Dialect})
+	d.data = raw.Payload
+	d.readValue(raw.Tag, reflect.ValueOf(v).Elem())
+	if d.off != len(raw.Payload) {
+		panic(fmt.Errorf("nbt: %d bytes left over after %s payload", len(raw.Payload)-d.off, raw.Tag))
+	}
+	return
+}
+
+var rawTagType = reflect.TypeOf(RawTag{})
+
+func (d *decodeState) readRaw(tag Tag) RawTag {
+	if d.in == nil {
+		start := d.off
+		d.skip(tag)
+		payload := d.data[start:d.off:d.off]
+		if !d.NoCopy {
+			payload = append([]byte(nil), payload...)
+		}
+		return RawTag{tag, payload, d.Dialect}
+	}
+
+	d.recorded = []byte{}
+	d.skip(tag)
+	payload := d.recorded
+	d.recorded = nil
+	return RawTag{tag, payload, d.Dialect}
+}
+
+// Reads past a payload without decoding it.
+func (d *decodeState) skip(tag Tag) {
+	switch tag {
+	case TagByte:
+		d.next(1)
+	case TagShort:
+		d.next(2)
+	case TagInt:
+		d.readInt32()
+	case TagFloat:
+		d.next(4)
+	case TagLong:
+		d.readInt64()
+	case TagDouble:
+		d.next(8)
+	case TagByteArray:
+		d.skipBytes(d.readLen())
+	case TagString:
+		d.skipBytes(d.readStringLen())
+	case TagIntArray, TagLongArray:
+		inner, size := TagInt, 4
+		if tag == TagLongArray {
+			inner, size = TagLong, 8
+		}
+		length := d.readLen()
+		if d.Dialect != BedrockNetwork {
+			d.skipBytes(size * length)
+			break
+		}
+		// Elements are varints, so each one has to be read.
+		for i := 0; i < length; i++ {
+			d.skip(inner)
+		}
+
+	case TagList:
+		inner := Tag(d.readU8())
+		length := d.readLen()
+		for i := 0; i < length; i++ {
+			d.skip(inner)
+		}
+
+	case TagCompound:
+		for {
+			tag := Tag(d.readU8())
+			if tag == TagEnd {
+				break
+			}
+			d.skipBytes(d.readStringLen())
+			d.skip(tag)
+		}
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+	}
+}
+
+// The most skipBytes reads from a stream at once.
+const skipChunk = 32 << 10
+
+// Reads past n bytes of input. Streams are read in chunks of at most skipChunk
+// bytes, so a corrupt length can only make it read until EOF, not allocate a
+// buffer of that size up front.
+func (d *decodeState) skipBytes(n int) {
+	if d.in == nil || n <= skipChunk {
+		d.next(n)
+		return
+	}
+	buf := make([]byte, skipChunk)
+	for n > 0 {
+		b := buf[:min(n, skipChunk)]
+		if _, err := io.ReadFull(d.in, b); err != nil {
+			panic(err)
+		}
+		d.off += len(b)
+		if d.recorded != nil {
+			d.recorded = append(d.recorded, b...)
+		}
+		n -= len(b)
+	}
+}
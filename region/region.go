@@ -0,0 +1,356 @@
+// Package region reads and writes Minecraft region files (.mca and .mcr). A
+// region file holds the chunks of a 32 by 32 chunk area of a world, each one
+// a compressed NBT document.
+//
+// Chunks are addressed by their chunk coordinates. Only the low 5 bits of each
+// coordinate are used, so both world and region-relative coordinates work.
+package region
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	nbt "github.com/Nightgunner5/go.nbt"
+)
+
+const (
+	// The size of the units space in a region file is allocated in.
+	SectorSize = 4096
+
+	// The number of chunks along each side of a region.
+	Width = 32
+
+	// The most sectors one chunk can take up.
+	MaxSectors = 255
+
+	headerSectors = 2
+	chunkCount    = Width * Width
+)
+
+// The compression schemes region files record for each chunk.
+const (
+	schemeGZip         = 1
+	schemeZLib         = 2
+	schemeUncompressed = 3
+	schemeExternal     = 0x80 // Flag for data kept in a separate .mcc file.
+)
+
+// A File is an open region file.
+type File struct {
+	f          *os.File
+	locations  [chunkCount]uint32 // First sector << 8 | sector count.
+	timestamps [chunkCount]uint32
+}
+
+// Opens a region file for reading.
+func Open(name string) (*File, error) {
+	return OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Opens a region file with the given flags, as os.OpenFile does. An empty
+// file opened for writing, such as one just created, is given an empty
+// header.
+func OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	r := &File{f: f}
+	if err := r.readHeader(flag); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("region: %s: %v", name, err)
+	}
+	return r, nil
+}
+
+func (r *File) readHeader(flag int) error {
+	info, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		_, err := r.f.WriteAt(make([]byte, headerSectors*SectorSize), 0)
+		return err
+	}
+
+	header := make([]byte, headerSectors*SectorSize)
+	if _, err := r.f.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("file is too short to be a region file")
+		}
+		return err
+	}
+	for i := range r.locations {
+		r.locations[i] = binary.BigEndian.Uint32(header[4*i:])
+		r.timestamps[i] = binary.BigEndian.Uint32(header[SectorSize+4*i:])
+	}
+	return nil
+}
+
+func (r *File) Close() error {
+	return r.f.Close()
+}
+
+func index(x, z int) int {
+	return (x & (Width - 1)) + (z&(Width-1))*Width
+}
+
+// Reports whether the chunk is present.
+func (r *File) Has(x, z int) bool {
+	return r.locations[index(x, z)] != 0
+}
+
+// Information about a chunk in a region file.
+type ChunkInfo struct {
+	X, Z        int // Region-relative coordinates, from 0 to 31.
+	Sector      int // The first sector of the chunk's data.
+	Sectors     int // The number of sectors allocated to the chunk.
+	Length      int // The length of the compressed data in bytes.
+	Compression nbt.Compression
+	External    bool // The data is kept in a separate .mcc file.
+	Modified    time.Time
+}
+
+// Returns the chunks present in the file, ordered by their coordinates, z
+// first.
+func (r *File) Chunks() ([]ChunkInfo, error) {
+	var chunks []ChunkInfo
+	for i, loc := range r.locations {
+		if loc == 0 {
+			continue
+		}
+		info := ChunkInfo{
+			X:        i % Width,
+			Z:        i / Width,
+			Sector:   int(loc >> 8),
+			Sectors:  int(loc & 0xff),
+			Modified: time.Unix(int64(r.timestamps[i]), 0),
+		}
+		length, scheme, err := r.chunkHeader(info.X, info.Z)
+		if err != nil {
+			return nil, err
+		}
+		info.Length = length
+		info.External = scheme&schemeExternal != 0
+		if info.Compression, err = compression(info.X, info.Z, scheme&^schemeExternal); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, info)
+	}
+	return chunks, nil
+}
+
+// Returns the length and compression scheme of a chunk.
+func (r *File) chunkHeader(x, z int) (int, byte, error) {
+	var header [5]byte
+	offset := int64(r.locations[index(x, z)]>>8) * SectorSize
+	if _, err := r.f.ReadAt(header[:], offset); err != nil {
+		return 0, 0, fmt.Errorf("region: Chunk (%d, %d): %v", x&(Width-1), z&(Width-1), err)
+	}
+	length := int(binary.BigEndian.Uint32(header[:])) - 1
+	if length < 0 || length+5 > int(r.locations[index(x, z)]&0xff)*SectorSize {
+		return 0, 0, fmt.Errorf("region: Chunk (%d, %d) has a bad length %d", x&(Width-1), z&(Width-1), length)
+	}
+	return length, header[4], nil
+}
+
+func compression(x, z int, scheme byte) (nbt.Compression, error) {
+	switch scheme {
+	case schemeGZip:
+		return nbt.GZip, nil
+	case schemeZLib:
+		return nbt.ZLib, nil
+	case schemeUncompressed:
+		return nbt.Uncompressed, nil
+	}
+	return 0, fmt.Errorf("region: Chunk (%d, %d) uses unsupported compression scheme %d", x&(Width-1), z&(Width-1), scheme)
+}
+
+// Returns the compressed data of a chunk and how it is compressed. Chunks kept
+// in external .mcc files are not supported.
+func (r *File) ReadChunk(x, z int) ([]byte, nbt.Compression, error) {
+	if !r.Has(x, z) {
+		return nil, 0, fmt.Errorf("region: Chunk (%d, %d) is not present", x&(Width-1), z&(Width-1))
+	}
+	length, scheme, err := r.chunkHeader(x, z)
+	if err != nil {
+		return nil, 0, err
+	}
+	if scheme&schemeExternal != 0 {
+		return nil, 0, fmt.Errorf("region: Chunk (%d, %d) is stored in an external file", x&(Width-1), z&(Width-1))
+	}
+	c, err := compression(x, z, scheme)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data := make([]byte, length)
+	offset := int64(r.locations[index(x, z)]>>8)*SectorSize + 5
+	if _, err := r.f.ReadAt(data, offset); err != nil {
+		return nil, 0, fmt.Errorf("region: Chunk (%d, %d): %v", x&(Width-1), z&(Width-1), err)
+	}
+	return data, c, nil
+}
+
+// Writes the compressed data of a chunk, replacing the chunk if it is present,
+// and sets its timestamp to now. The chunk is written in place if it fits, and
+// otherwise in the first free sectors that are large enough.
+func (r *File) WriteChunk(x, z int, data []byte, compression nbt.Compression) error {
+	var scheme byte
+	switch compression {
+	case nbt.GZip:
+		scheme = schemeGZip
+	case nbt.ZLib:
+		scheme = schemeZLib
+	case nbt.Uncompressed:
+		scheme = schemeUncompressed
+	default:
+		return fmt.Errorf("region: Unknown compression type: %d", compression)
+	}
+
+	sectors := (len(data) + 5 + SectorSize - 1) / SectorSize
+	if sectors > MaxSectors {
+		return fmt.Errorf("region: Chunk (%d, %d) is %d bytes, which is too large for a region file", x&(Width-1), z&(Width-1), len(data))
+	}
+
+	i := index(x, z)
+	start := int(r.locations[i] >> 8)
+	if sectors > int(r.locations[i]&0xff) {
+		// The chunk's old sectors are free to reuse.
+		old := r.locations[i]
+		r.locations[i] = 0
+		start = r.findFree(sectors)
+		r.locations[i] = old
+	}
+
+	buf := make([]byte, sectors*SectorSize)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = scheme
+	copy(buf[5:], data)
+	if _, err := r.f.WriteAt(buf, int64(start)*SectorSize); err != nil {
+		return err
+	}
+
+	r.locations[i] = uint32(start)<<8 | uint32(sectors)
+	r.timestamps[i] = uint32(time.Now().Unix())
+	return r.writeEntry(i)
+}
+
+// Returns the first sector of the first run of free sectors that is at least n
+// long, which may be at the end of the file.
+func (r *File) findFree(n int) int {
+	type span struct{ start, end int }
+	var used []span
+	for _, loc := range r.locations {
+		if loc != 0 {
+			used = append(used, span{int(loc >> 8), int(loc>>8) + int(loc&0xff)})
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].start < used[j].start })
+
+	free := headerSectors
+	for _, s := range used {
+		if s.start-free >= n {
+			break
+		}
+		if s.end > free {
+			free = s.end
+		}
+	}
+	return free
+}
+
+// Writes a chunk's entries in the header.
+func (r *File) writeEntry(i int) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], r.locations[i])
+	if _, err := r.f.WriteAt(b[:], int64(4*i)); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b[:], r.timestamps[i])
+	_, err := r.f.WriteAt(b[:], int64(SectorSize+4*i))
+	return err
+}
+
+// Removes a chunk. Its sectors are left in the file until it is compacted.
+func (r *File) DeleteChunk(x, z int) error {
+	i := index(x, z)
+	r.locations[i] = 0
+	r.timestamps[i] = 0
+	return r.writeEntry(i)
+}
+
+// Decodes a chunk into v as nbt.Unmarshal would.
+func (r *File) Unmarshal(x, z int, v interface{}) error {
+	data, c, err := r.ReadChunk(x, z)
+	if err != nil {
+		return err
+	}
+	return nbt.Unmarshal(c, bytes.NewReader(data), v)
+}
+
+// Encodes v as nbt.Marshal would and writes it as a zlib compressed chunk, the
+// way Minecraft writes them.
+func (r *File) Marshal(x, z int, v interface{}) error {
+	var buf bytes.Buffer
+	if err := nbt.Marshal(nbt.ZLib, &buf, v); err != nil {
+		return err
+	}
+	return r.WriteChunk(x, z, buf.Bytes(), nbt.ZLib)
+}
+
+// Moves the chunks down to close the gaps between them and shortens the file
+// to fit. The chunks keep their order in the file.
+func (r *File) Compact() error {
+	var order []int
+	for i, loc := range r.locations {
+		if loc != 0 {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool { return r.locations[order[a]] < r.locations[order[b]] })
+
+	next := headerSectors
+	for _, i := range order {
+		start, n := int(r.locations[i]>>8), int(r.locations[i]&0xff)
+		if n == 0 {
+			continue
+		}
+		if start < next {
+			return fmt.Errorf("region: Chunk (%d, %d) overlaps another chunk", i%Width, i/Width)
+		}
+		if start != next {
+			// Chunks only ever move towards the start of the file, so this
+			// never overwrites a chunk that has yet to be moved.
+			buf := make([]byte, n*SectorSize)
+			if _, err := r.f.ReadAt(buf, int64(start)*SectorSize); err != nil && err != io.EOF {
+				return err
+			}
+			if _, err := r.f.WriteAt(buf, int64(next)*SectorSize); err != nil {
+				return err
+			}
+			r.locations[i] = uint32(next)<<8 | uint32(n)
+			if err := r.writeEntry(i); err != nil {
+				return err
+			}
+		}
+		next += n
+	}
+	return r.f.Truncate(int64(next) * SectorSize)
+}
+
+// Returns the region coordinates in a region file's name, like r.-1.2.mca.
+func ParseName(name string) (x, z int, ok bool) {
+	var ext string
+	n, err := fmt.Sscanf(filepath.Base(name), "r.%d.%d.%s", &x, &z, &ext)
+	if err != nil || n != 3 || (ext != "mca" && ext != "mcr") {
+		return 0, 0, false
+	}
+	return x, z, true
+}
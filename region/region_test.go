@@ -0,0 +1,105 @@
+package region
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type Chunk struct {
+	Level struct {
+		XPos int32 `nbt:"xPos"`
+		ZPos int32 `nbt:"zPos"`
+		Data []byte
+	}
+}
+
+func TestRegion(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "r.-1.2.mca")
+	r, err := OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Noise doesn't compress, so these take up 1, 4 and 2 sectors.
+	for i, size := range []int{10, 3 * SectorSize, SectorSize} {
+		var c Chunk
+		c.Level.XPos, c.Level.ZPos = int32(-32+i), 64
+		c.Level.Data = noise(size)
+		if err := r.Marshal(-32+i, 64, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Has(3, 0) || !r.Has(1, 0) {
+		t.Error("Has reports the wrong chunks")
+	}
+
+	chunks, err := r.Chunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 || chunks[1].X != 1 || chunks[1].Z != 0 || chunks[1].Sector != 3 {
+		t.Errorf("Listed %+v", chunks)
+	}
+
+	// Growing the first chunk moves it to the end; the gap it leaves is then
+	// closed by compacting.
+	var c Chunk
+	c.Level.Data = noise(2 * SectorSize)
+	if err := r.Marshal(0, 0, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteChunk(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := r.f.Stat(); info.Size() != (2+4+3)*SectorSize {
+		t.Errorf("Compacted file is %d bytes", info.Size())
+	}
+	r.Close()
+
+	r, err = Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Chunk
+	if err := r.Unmarshal(1, 0, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Level.XPos != -31 || len(result.Level.Data) != 3*SectorSize {
+		t.Errorf("Read back chunk at (%d, %d) with %d bytes of data", result.Level.XPos, result.Level.ZPos, len(result.Level.Data))
+	}
+	if err := r.Unmarshal(0, 0, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.Level.Data, c.Level.Data) {
+		t.Error("Moved chunk has different data")
+	}
+	if err := r.Unmarshal(2, 0, &result); err == nil {
+		t.Error("No error, but one was expected!")
+	}
+
+	if x, z, ok := ParseName(name); x != -1 || z != 2 || !ok {
+		t.Errorf("Parsed %s as %d, %d, %v", name, x, z, ok)
+	}
+	if _, _, ok := ParseName("level.dat"); ok {
+		t.Error("Parsed level.dat as a region file name")
+	}
+}
+
+// Returns bytes that don't compress.
+func noise(n int) []byte {
+	b := make([]byte, n)
+	x := uint32(1)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+	return b
+}
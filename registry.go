@@ -0,0 +1,127 @@
+package nbt
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Registry maps the value of a discriminator tag, such as the "id" of an
+// entity or block entity, to the Go type that compounds with that value are
+// decoded into.
+//
+// When a Decoder with a Registry decodes a compound into an interface value,
+// including the elements of a list of interfaces, it looks at the
+// discriminator and decodes into the registered type instead of a map. If
+// nothing suitable is registered, compounds still decode into
+// map[string]interface{} where that is allowed. An Encoder with a Registry
+// writes the discriminator for registered struct types that don't have a field
+// for it themselves.
+//
+// Types should be registered before the Registry is used; Register must not be
+// called while decoding or encoding is in progress.
+type Registry struct {
+	Key string // The name of the discriminator tag.
+
+	types map[string]reflect.Type
+	ids   map[reflect.Type]string
+}
+
+func NewRegistry(key string) *Registry {
+	return &Registry{
+		Key:   key,
+		types: make(map[string]reflect.Type),
+		ids:   make(map[reflect.Type]string),
+	}
+}
+
+// Registers the type of v for compounds whose discriminator is id. If v is a
+// pointer, such as (*Chest)(nil), interface values are given pointers to new
+// values of the type it points to.
+func (r *Registry) Register(id string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if base := t; base.Kind() == reflect.Ptr {
+		base = base.Elem()
+		if base.Kind() != reflect.Struct {
+			panic(fmt.Errorf("nbt: Registered type must be a struct, but %v is not", t))
+		}
+		r.ids[base] = id
+	} else if base.Kind() != reflect.Struct {
+		panic(fmt.Errorf("nbt: Registered type must be a struct, but %v is not", t))
+	}
+
+	r.types[id] = t
+	r.ids[t] = id
+}
+
+// Returns the discriminator key if values of the struct type t should have one.
+func (r *Registry) keyFor(t reflect.Type) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	_, ok := r.ids[t]
+	return r.Key, ok
+}
+
+// Returns the name of the discriminator tag for a registered struct type that
+// has no field of its own for it, and whether there is one.
+func (d *decodeState) discriminator(t reflect.Type) (string, bool) {
+	key, ok := d.Registry.keyFor(t)
+	if !ok {
+		return "", false
+	}
+	if _, exists := cachedFields(t).named(key); exists {
+		return "", false
+	}
+	return key, true
+}
+
+// Decodes a compound into the interface value v using the registry.
+func (d *decodeState) readRegistered(v reflect.Value) {
+	// In memory, the compound can be looked at where it is. A stream has to
+	// be captured first, to read it a second time.
+	var data []byte
+	if d.in == nil {
+		data = d.data[d.off:]
+	} else {
+		data = d.readRaw(TagCompound).Payload
+	}
+
+	id, ok := d.findDiscriminator(data)
+	var t reflect.Type
+	if ok {
+		t = d.Registry.types[id]
+	}
+	if t == nil || !t.AssignableTo(v.Type()) {
+		t = reflect.TypeOf(map[string]interface{}(nil))
+		if !t.AssignableTo(v.Type()) {
+			panic(fmt.Errorf("nbt: No type that fits in a %s is registered for %s %#v", v.Type(), d.Registry.Key, id))
+		}
+	}
+
+	value := reflect.New(t).Elem()
+	if d.in == nil {
+		d.readValue(TagCompound, value)
+	} else {
+		sub := newDecodeState(d.Decoder)
+		sub.data = data
+		sub.readValue(TagCompound, value)
+	}
+	v.Set(value)
+}
+
+// Returns the value of the discriminator in the compound payload at the start
+// of data, skipping over the entries before it.
+func (d *decodeState) findDiscriminator(data []byte) (string, bool) {
+	scan := newDecodeState(d.Decoder)
+	scan.data = data
+	for {
+		name, tag := scan.readTag()
+		switch {
+		case tag == TagEnd:
+			return "", false
+		case tag == TagString && name == d.Registry.Key:
+			return scan.readString(), true
+		}
+		scan.skip(tag)
+	}
+}
@@ -0,0 +1,311 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// A Schema describes the tags a document, or a part of one, may hold. Schemas
+// can be written by hand or inferred from sample documents:
+//
+//	schema := &nbt.Schema{Tag: nbt.TagCompound, Fields: []nbt.Field{
+//		{Name: "id", Schema: &nbt.Schema{Tag: nbt.TagString, Enum: []string{"minecraft:pig", "minecraft:cow"}}},
+//		{Name: "Health", Schema: &nbt.Schema{Tag: nbt.TagFloat, Range: &nbt.Range{Min: 0, Max: 20}}},
+//		{Name: "CustomName", Schema: &nbt.Schema{Tag: nbt.TagString}, Optional: true},
+//	}}
+type Schema struct {
+	// The tag, or TagEnd for any tag. As Minecraft's own readers do, a
+	// number is also accepted where a wider number is expected, so a TAG_Byte
+	// is a valid TAG_Int and a TAG_Int is a valid TAG_Double.
+	Tag Tag
+
+	// For lists and arrays, the schema of every element, or nil for any
+	// elements.
+	Elem *Schema
+
+	// For compounds, the entries they may hold, and whether they may also
+	// hold others.
+	Fields []Field
+	Extra  bool
+
+	// For numbers, and arrays of them through Elem, the range they must be
+	// in, or nil for any value.
+	Range *Range
+
+	// For strings, the values they may have, or nil for any value.
+	Enum []string
+}
+
+type Field struct {
+	Name     string
+	Schema   *Schema
+	Optional bool
+}
+
+// An inclusive range of numbers.
+type Range struct {
+	Min, Max float64
+}
+
+// Returns the field with the given name.
+func (schema *Schema) Field(name string) (*Field, bool) {
+	for i := range schema.Fields {
+		if schema.Fields[i].Name == name {
+			return &schema.Fields[i], true
+		}
+	}
+	return nil, false
+}
+
+// Returns the schema of a document. Inferred schemas record only the
+// structure of the document: they have no ranges or enums, and their
+// compounds allow no other entries.
+func InferSchema(compression Compression, in io.Reader) (*Schema, error) {
+	return new(Decoder).InferSchema(compression, in)
+}
+
+func (dec *Decoder) InferSchema(compression Compression, in io.Reader) (schema *Schema, err error) {
+	defer recoverError(&err)
+
+	d := newDecodeState(dec).init(compression, in)
+	_, tag := d.readTag()
+	return d.inferSchema(tag), nil
+}
+
+func (d *decodeState) inferSchema(tag Tag) *Schema {
+	schema := &Schema{Tag: tag}
+	switch tag {
+	case TagList:
+		inner := Tag(d.readU8())
+		length := d.readLen()
+		for i := 0; i < length; i++ {
+			schema.Elem = MergeSchemas(schema.Elem, d.inferSchema(inner))
+		}
+		if length == 0 && inner != TagEnd {
+			schema.Elem = &Schema{Tag: inner}
+		}
+
+	case TagCompound:
+		for {
+			name, tag := d.readTag()
+			if tag == TagEnd {
+				break
+			}
+			schema.Fields = append(schema.Fields, Field{Name: name, Schema: d.inferSchema(tag)})
+		}
+
+	case TagEnd:
+
+	default:
+		d.skip(tag)
+	}
+	return schema
+}
+
+// The order numbers widen in.
+var numberWidths = map[Tag]int{
+	TagByte: 1, TagShort: 2, TagInt: 3, TagLong: 4, TagFloat: 5, TagDouble: 6,
+}
+
+// Returns a schema that accepts everything a or b accepts, for combining the
+// schemas inferred from several samples. Either may be nil. Numbers of
+// different widths widen to the wider, other tags that differ become any tag,
+// and compound entries that are not in both become optional. Ranges and
+// enums are combined if both have them, and dropped otherwise.
+func MergeSchemas(a, b *Schema) *Schema {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.Tag == TagEnd || b.Tag == TagEnd:
+		return &Schema{}
+	}
+
+	if a.Tag != b.Tag {
+		wa, okA := numberWidths[a.Tag]
+		wb, okB := numberWidths[b.Tag]
+		if !okA || !okB {
+			return &Schema{}
+		}
+		if wb > wa {
+			a, b = b, a
+		}
+	}
+
+	merged := &Schema{
+		Tag:   a.Tag,
+		Elem:  MergeSchemas(a.Elem, b.Elem),
+		Extra: a.Extra || b.Extra,
+	}
+	if a.Range != nil && b.Range != nil {
+		merged.Range = &Range{Min: a.Range.Min, Max: a.Range.Max}
+		if b.Range.Min < merged.Range.Min {
+			merged.Range.Min = b.Range.Min
+		}
+		if b.Range.Max > merged.Range.Max {
+			merged.Range.Max = b.Range.Max
+		}
+	}
+	if a.Enum != nil && b.Enum != nil {
+		merged.Enum = append([]string(nil), a.Enum...)
+		for _, s := range b.Enum {
+			if !containsString(merged.Enum, s) {
+				merged.Enum = append(merged.Enum, s)
+			}
+		}
+	}
+
+	if a.Tag == TagCompound && b.Tag == TagCompound {
+		for _, fa := range a.Fields {
+			f := fa
+			if fb, ok := b.Field(fa.Name); ok {
+				f.Schema = MergeSchemas(fa.Schema, fb.Schema)
+				f.Optional = fa.Optional || fb.Optional
+			} else {
+				f.Optional = true
+			}
+			merged.Fields = append(merged.Fields, f)
+		}
+		for _, fb := range b.Fields {
+			if _, ok := a.Field(fb.Name); !ok {
+				fb.Optional = true
+				merged.Fields = append(merged.Fields, fb)
+			}
+		}
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A Violation is one way a document does not match a schema.
+type Violation struct {
+	Path    string // Where in the document, e.g. Inventory[3].Count
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// A SchemaError lists every way a document does not match a schema.
+type SchemaError struct {
+	Violations []Violation
+}
+
+func (err *SchemaError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nbt: Document does not match the schema:")
+	for _, v := range err.Violations {
+		b.WriteString("\n\t")
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// Checks that a document matches a schema. If it doesn't, the error is a
+// *SchemaError that lists every violation, not just the first.
+func Validate(compression Compression, in io.Reader, schema *Schema) error {
+	return new(Decoder).Validate(compression, in, schema)
+}
+
+func (dec *Decoder) Validate(compression Compression, in io.Reader, schema *Schema) error {
+	var root interface{}
+	if err := dec.Unmarshal(compression, in, &root); err != nil {
+		return err
+	}
+	return ValidateTree(root, schema)
+}
+
+// Like Validate, but for a tree of values like the ones Unmarshal produces
+// when decoding into an interface{}.
+func ValidateTree(v interface{}, schema *Schema) (err error) {
+	defer recoverError(&err)
+
+	var violations []Violation
+	validate(v, schema, nil, &violations)
+	if len(violations) != 0 {
+		return &SchemaError{violations}
+	}
+	return nil
+}
+
+func validate(v interface{}, schema *Schema, at []pathElem, violations *[]Violation) {
+	if schema == nil {
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		*violations = append(*violations, Violation{formatPath(at), fmt.Sprintf(format, args...)})
+	}
+
+	tag, ok := dynamicTag(v)
+	if !ok {
+		panic(fmt.Errorf("nbt: Unhandled type: %T (%v)", v, v))
+	}
+	if schema.Tag != TagEnd && tag != schema.Tag {
+		want, okWant := numberWidths[schema.Tag]
+		got, okGot := numberWidths[tag]
+		if !okWant || !okGot || got > want {
+			report("expected %s, but found %s", schema.Tag, tag)
+			return
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if schema.Enum != nil && !containsString(schema.Enum, v) {
+			report("%q is not one of %q", v, schema.Enum)
+		}
+
+	case map[string]interface{}:
+		for _, f := range schema.Fields {
+			at := append(at, pathElem{f.Name, -1})
+			if el, ok := v[f.Name]; ok {
+				validate(el, f.Schema, at, violations)
+			} else if !f.Optional {
+				*violations = append(*violations, Violation{formatPath(at), "required, but missing"})
+			}
+		}
+		if !schema.Extra {
+			for _, name := range sortedKeys(v) {
+				if _, ok := schema.Field(name); !ok {
+					at := append(at, pathElem{name, -1})
+					*violations = append(*violations, Violation{formatPath(at), "not in the schema"})
+				}
+			}
+		}
+
+	case []interface{}, []byte, []int32, []int64:
+		for i, n := 0, listLen(v); i < n; i++ {
+			validate(listIndex(v, i), schema.Elem, append(at, pathElem{index: i}), violations)
+		}
+
+	default:
+		if schema.Range != nil {
+			n := reflect.ValueOf(v)
+			var f float64
+			switch n.Kind() {
+			case reflect.Float32, reflect.Float64:
+				f = n.Float()
+			default:
+				f = float64(n.Int())
+			}
+			if f < schema.Range.Min || f > schema.Range.Max {
+				report("%v is not between %v and %v", v, schema.Range.Min, schema.Range.Max)
+			}
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package nbt
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SNBT ("stringified NBT") is the text form of NBT used in Minecraft commands,
+// e.g. {Slot:3b,id:"minecraft:stone",Count:1b,tag:{Damage:0}}
+
+// Parses SNBT into the same kinds of values Unmarshal produces when decoding
+// into an interface{}: int8, int16, int32, int64, float32, float64, string,
+// []byte, []int32, []int64, []interface{} and map[string]interface{}.
+func ParseSNBT(s string) (v interface{}, err error) {
+	defer recoverError(&err)
+
+	p := &snbtParser{s: s}
+	v = p.value()
+	p.space()
+	if p.pos != len(p.s) {
+		p.fail("Unexpected %q after value", p.s[p.pos:])
+	}
+	return
+}
+
+type snbtParser struct {
+	s   string
+	pos int
+}
+
+func (p *snbtParser) fail(format string, args ...interface{}) {
+	panic(fmt.Errorf("nbt: Invalid SNBT at offset %d: %s", p.pos, fmt.Sprintf(format, args...)))
+}
+
+func (p *snbtParser) space() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// Returns the next non-space byte without consuming it, or 0 at the end.
+func (p *snbtParser) peek() byte {
+	p.space()
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *snbtParser) expect(c byte) {
+	if p.peek() != c {
+		if p.pos == len(p.s) {
+			p.fail("Expected %q, but the input ended", c)
+		}
+		p.fail("Expected %q, but found %q", c, p.s[p.pos])
+	}
+	p.pos++
+}
+
+func (p *snbtParser) value() interface{} {
+	switch p.peek() {
+	case '{':
+		return p.compound()
+	case '[':
+		return p.list()
+	case '"', '\'':
+		return p.quoted()
+	case 0:
+		p.fail("Expected a value, but the input ended")
+	}
+	return p.scalar(p.unquoted())
+}
+
+func (p *snbtParser) compound() map[string]interface{} {
+	p.expect('{')
+	compound := make(map[string]interface{})
+	if p.peek() == '}' {
+		p.pos++
+		return compound
+	}
+
+	for {
+		key := p.key()
+		p.expect(':')
+		compound[key] = p.value()
+
+		if p.peek() == '}' {
+			p.pos++
+			return compound
+		}
+		p.expect(',')
+	}
+}
+
+func (p *snbtParser) key() string {
+	switch p.peek() {
+	case '"', '\'':
+		return p.quoted()
+	}
+	key := p.unquoted()
+	if key == "" {
+		p.fail("Expected a key")
+	}
+	return key
+}
+
+func (p *snbtParser) list() interface{} {
+	p.expect('[')
+
+	if p.pos+1 < len(p.s) && p.s[p.pos+1] == ';' {
+		kind := p.s[p.pos]
+		p.pos += 2
+		switch kind {
+		case 'B':
+			return p.array(TagByte, []byte{})
+		case 'I':
+			return p.array(TagInt, []int32{})
+		case 'L':
+			return p.array(TagLong, []int64{})
+		}
+		p.pos -= 2
+		p.fail("Unknown array type %q", kind)
+	}
+
+	list := []interface{}{}
+	if p.peek() == ']' {
+		p.pos++
+		return list
+	}
+
+	var tag Tag
+	for {
+		start := p.pos
+		value := p.value()
+		if t, _ := dynamicTag(value); len(list) == 0 {
+			tag = t
+		} else if t != tag {
+			p.pos = start
+			p.fail("List elements must all have the same tag, but this is %s and the first was %s", t, tag)
+		}
+		list = append(list, value)
+
+		if p.peek() == ']' {
+			p.pos++
+			return list
+		}
+		p.expect(',')
+	}
+}
+
+// Parses the elements of a typed array. Suffixes on the numbers are optional,
+// but must match the array type if given.
+func (p *snbtParser) array(tag Tag, array interface{}) interface{} {
+	if p.peek() == ']' {
+		p.pos++
+		return array
+	}
+
+	for {
+		start := p.pos
+		p.space()
+		word := p.unquoted()
+		n, ok := parseInt(word, tag)
+		if !ok {
+			p.pos = start
+			p.fail("Expected a %s array element, but found %q", tag, word)
+		}
+
+		switch a := array.(type) {
+		case []byte:
+			array = append(a, byte(n))
+		case []int32:
+			array = append(a, int32(n))
+		case []int64:
+			array = append(a, n)
+		}
+
+		if p.peek() == ']' {
+			p.pos++
+			return array
+		}
+		p.expect(',')
+	}
+}
+
+func (p *snbtParser) quoted() string {
+	q := p.s[p.pos]
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == q:
+			return b.String()
+		case c == '\\' && p.pos < len(p.s):
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	p.fail("Unterminated string")
+	return ""
+}
+
+// Returns the run of characters that may appear in an unquoted string.
+func (p *snbtParser) unquoted() string {
+	start := p.pos
+	for p.pos < len(p.s) && (isBareChar(rune(p.s[p.pos])) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+var (
+	snbtDouble = regexp.MustCompile(`^[-+]?(?:[0-9]+\.?|[0-9]*\.[0-9]+)(?:[eE][-+]?[0-9]+)?[dD]$|^[-+]?(?:[0-9]+\.|[0-9]*\.[0-9]+)(?:[eE][-+]?[0-9]+)?$`)
+	snbtFloat  = regexp.MustCompile(`^[-+]?(?:[0-9]+\.?|[0-9]*\.[0-9]+)(?:[eE][-+]?[0-9]+)?[fF]$`)
+	snbtInt    = regexp.MustCompile(`^[-+]?(?:0|[1-9][0-9]*)[bBsSlL]?$`)
+)
+
+// Interprets an unquoted word the way Minecraft does: as a number if it looks
+// like one and fits, as a byte for true and false, and as a string otherwise.
+func (p *snbtParser) scalar(word string) interface{} {
+	if word == "" {
+		p.fail("Expected a value, but found %q", p.s[p.pos])
+	}
+
+	switch {
+	case snbtDouble.MatchString(word):
+		if f, err := strconv.ParseFloat(strings.TrimRight(word, "dD"), 64); err == nil {
+			return f
+		}
+	case snbtFloat.MatchString(word):
+		if f, err := strconv.ParseFloat(word[:len(word)-1], 32); err == nil {
+			return float32(f)
+		}
+	case snbtInt.MatchString(word):
+		tag := TagInt
+		switch word[len(word)-1] {
+		case 'b', 'B':
+			tag = TagByte
+		case 's', 'S':
+			tag = TagShort
+		case 'l', 'L':
+			tag = TagLong
+		}
+		if n, ok := parseInt(word, tag); ok {
+			switch tag {
+			case TagByte:
+				return int8(n)
+			case TagShort:
+				return int16(n)
+			case TagInt:
+				return int32(n)
+			}
+			return n
+		}
+	case word == "true":
+		return int8(1)
+	case word == "false":
+		return int8(0)
+	}
+	return word
+}
+
+// Parses an integer that must fit in the given tag, with or without the
+// suffix for that tag.
+func parseInt(word string, tag Tag) (int64, bool) {
+	suffix, bits := "", 32
+	switch tag {
+	case TagByte:
+		suffix, bits = "bB", 8
+	case TagShort:
+		suffix, bits = "sS", 16
+	case TagLong:
+		suffix, bits = "lL", 64
+	}
+	if len(word) > 0 && strings.IndexByte(suffix, word[len(word)-1]) >= 0 {
+		word = word[:len(word)-1]
+	}
+	n, err := strconv.ParseInt(word, 10, bits)
+	return n, err == nil
+}
+
+// Formats a value as SNBT, with compound keys sorted. Values other than the
+// ones ParseSNBT returns are formatted as they would be encoded by Marshal.
+// It is an error for the value to hold a NaN or infinite float.
+func FormatSNBT(v interface{}) (s string, err error) {
+	defer recoverError(&err)
+	return string(appendSNBT(nil, toTree(v))), nil
+}
+
+// Returns a value in the form Unmarshal gives it when decoding into an
+// interface{}.
+func toTree(v interface{}) interface{} {
+	switch v.(type) {
+	case int8, int16, int32, int64, float32, float64, string,
+		[]byte, []int32, []int64, []interface{}, map[string]interface{}:
+		return v
+	}
+
+	data, err := AppendMarshal(nil, v)
+	if err != nil {
+		panic(err)
+	}
+	var tree interface{}
+	if _, err = UnmarshalBytes(data, &tree); err != nil {
+		panic(err)
+	}
+	return tree
+}
+
+// SNBT has numbers only in decimal, so there is no way to write NaN or the
+// infinities.
+func checkFinite(f float64) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		panic(fmt.Errorf("nbt: %v can't be written in SNBT", f))
+	}
+}
+
+func appendSNBT(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case int8:
+		return append(strconv.AppendInt(b, int64(v), 10), 'b')
+	case int16:
+		return append(strconv.AppendInt(b, int64(v), 10), 's')
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int64:
+		return append(strconv.AppendInt(b, v, 10), 'L')
+	case float32:
+		checkFinite(float64(v))
+		return append(strconv.AppendFloat(b, float64(v), 'g', -1, 32), 'f')
+	case float64:
+		checkFinite(v)
+		return append(strconv.AppendFloat(b, v, 'g', -1, 64), 'd')
+	case string:
+		return append(b, quote(v)...)
+
+	case []byte:
+		b = append(b, "[B;"...)
+		for i, n := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(strconv.AppendInt(b, int64(int8(n)), 10), 'b')
+		}
+		return append(b, ']')
+	case []int32:
+		b = append(b, "[I;"...)
+		for i, n := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, int64(n), 10)
+		}
+		return append(b, ']')
+	case []int64:
+		b = append(b, "[L;"...)
+		for i, n := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(strconv.AppendInt(b, n, 10), 'L')
+		}
+		return append(b, ']')
+
+	case []interface{}:
+		b = append(b, '[')
+		for i, el := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendSNBT(b, el)
+		}
+		return append(b, ']')
+
+	case map[string]interface{}:
+		b = append(b, '{')
+		for i, name := range sortedKeys(v) {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, snbtKey(name)...)
+			b = append(b, ':')
+			b = appendSNBT(b, v[name])
+		}
+		return append(b, '}')
+	}
+	panic(fmt.Errorf("nbt: Unhandled type: %T (%v)", v, v))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for name := range m {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Quotes a compound key if it can't be written bare in SNBT.
+func snbtKey(name string) string {
+	if name == "" {
+		return `""`
+	}
+	for _, c := range name {
+		if !isBareChar(c) && c != '.' {
+			return quote(name)
+		}
+	}
+	return name
+}
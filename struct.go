@@ -3,11 +3,76 @@ package nbt
 import (
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 )
 
-func parseStruct(v reflect.Value) map[string]reflect.Value {
-	parsed := make(map[string]reflect.Value)
-	t := v.Type()
+type field struct {
+	name  string
+	index int
+	typ   reflect.Type
+	opts  tagOptions
+}
+
+// The options that may follow a field name in an nbt struct tag, separated by
+// commas:
+//
+//	array:     encode a slice of bytes or of 32 or 64 bit integers as a
+//	           TAG_Byte_Array, TAG_Int_Array or TAG_Long_Array rather than as
+//	           a TAG_List.
+//	ticks:     store a time.Duration as a number of game ticks. It is an
+//	           error to encode one that isn't a whole number of ticks.
+//	millis:    store a time.Duration as a number of milliseconds, which
+//	           likewise must be whole.
+//	mostleast: store a UUID or *UUID as two TAG_Longs named after the field
+//	           with Most and Least appended, the way Minecraft did before
+//	           1.16. A nil *UUID is left out.
+var knownOptions = map[string]bool{
+	"array":     true,
+	"ticks":     true,
+	"millis":    true,
+	"mostleast": true,
+}
+
+type tagOptions []string
+
+func (opts tagOptions) has(opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a struct tag into the name and its options. Names may contain commas
+// themselves, so only known options are split off the end.
+func parseTag(tag string) (string, tagOptions) {
+	var opts tagOptions
+	for {
+		i := strings.LastIndexByte(tag, ',')
+		if i < 0 || !knownOptions[tag[i+1:]] {
+			return tag, opts
+		}
+		opts = append(opts, tag[i+1:])
+		tag = tag[:i]
+	}
+}
+
+type structFields struct {
+	list   []field        // In declaration order.
+	byName map[string]int // Indexes into list.
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// Returns the NBT fields of a struct type.
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{byName: make(map[string]int)}
 
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
@@ -15,20 +80,40 @@ func parseStruct(v reflect.Value) map[string]reflect.Value {
 			continue
 		}
 
-		name := f.Name
-		if tag := f.Tag.Get("nbt"); tag != "" {
-			name = tag
+		name, opts := parseTag(f.Tag.Get("nbt"))
+		if name == "" {
+			name = f.Name
 		}
 		if name == "-" {
 			continue
 		}
 
-		if _, exists := parsed[name]; exists {
+		if _, exists := fields.byName[name]; exists {
 			panic(fmt.Errorf("Multiple fields with name %#v", name))
 		}
+		fields.byName[name] = len(fields.list)
+		fields.list = append(fields.list, field{name, i, f.Type, opts})
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+}
+
+func parseStruct(v reflect.Value) map[string]reflect.Value {
+	parsed := make(map[string]reflect.Value)
 
-		parsed[name] = reflect.Indirect(v.Field(i))
+	for _, f := range cachedFields(v.Type()).list {
+		parsed[f.name] = reflect.Indirect(v.Field(f.index))
 	}
 
 	return parsed
 }
+
+// Returns the field with the given NBT name.
+func (fields *structFields) named(name string) (*field, bool) {
+	i, ok := fields.byName[name]
+	if !ok {
+		return nil, false
+	}
+	return &fields.list[i], true
+}
@@ -1,55 +1,57 @@
 package nbt
 
-import "fmt"
-
-// All tags are big endian.
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
 
 type Tag byte
 
 const (
-	tagEnd       Tag = iota // No payload, no name.
-	tagByte                 // Signed 8 bit integer.
-	tagShort                // Signed 16 bit integer.
-	tagInt                  // Signed 32 bit integer.
-	tagLong                 // Signed 64 bit integer.
-	tagFloat                // IEEE 754-2008 32 bit floating point number.
-	tagDouble               // IEEE 754-2008 64 bit floating point number.
-	tagByteArray            // size tagInt, then payload [size]byte.
-	tagString               // length tagShort, then payload (utf-8) string (of length length).
-	tagList                 // tagID tagByte, length tagInt, then payload [length]tagID.
-	tagCompound             // { tagID tagByte, name tagString, payload tagID }... tagEnd
-	tagIntArray             // size tagInt, then payload [size]tagInt
-	tagLongArray
+	TagEnd       Tag = iota // No payload, no name.
+	TagByte                 // Signed 8 bit integer.
+	TagShort                // Signed 16 bit integer.
+	TagInt                  // Signed 32 bit integer.
+	TagLong                 // Signed 64 bit integer.
+	TagFloat                // IEEE 754-2008 32 bit floating point number.
+	TagDouble               // IEEE 754-2008 64 bit floating point number.
+	TagByteArray            // size TagInt, then payload [size]byte.
+	TagString               // length TagShort, then payload (utf-8, modified for Java) string (of length length).
+	TagList                 // tagID TagByte, length TagInt, then payload [length]tagID.
+	TagCompound             // { tagID TagByte, name TagString, payload tagID }... TagEnd
+	TagIntArray             // size TagInt, then payload [size]TagInt
+	TagLongArray            // size TagInt, then payload [size]TagLong
 )
 
 func (tag Tag) String() string {
 	name := "Unknown"
 	switch tag {
-	case tagEnd:
+	case TagEnd:
 		name = "TAG_End"
-	case tagByte:
+	case TagByte:
 		name = "TAG_Byte"
-	case tagShort:
+	case TagShort:
 		name = "TAG_Short"
-	case tagInt:
+	case TagInt:
 		name = "TAG_Int"
-	case tagLong:
+	case TagLong:
 		name = "TAG_Long"
-	case tagFloat:
+	case TagFloat:
 		name = "TAG_Float"
-	case tagDouble:
+	case TagDouble:
 		name = "TAG_Double"
-	case tagByteArray:
+	case TagByteArray:
 		name = "TAG_Byte_Array"
-	case tagString:
+	case TagString:
 		name = "TAG_String"
-	case tagList:
+	case TagList:
 		name = "TAG_List"
-	case tagCompound:
+	case TagCompound:
 		name = "TAG_Compound"
-	case tagIntArray:
+	case TagIntArray:
 		name = "TAG_Int_Array"
-	case tagLongArray:
+	case TagLongArray:
 		name = "TAG_Long_Array"
 	}
 	return fmt.Sprintf("%s (0x%02x)", name, byte(tag))
@@ -62,3 +64,95 @@ const (
 	GZip
 	ZLib
 )
+
+func (compression Compression) String() string {
+	switch compression {
+	case Uncompressed:
+		return "none"
+	case GZip:
+		return "gzip"
+	case ZLib:
+		return "zlib"
+	}
+	return fmt.Sprintf("Compression(%d)", byte(compression))
+}
+
+// Returns the compression with the given name: none, gzip or zlib.
+func ParseCompression(name string) (Compression, error) {
+	for c := Uncompressed; c <= ZLib; c++ {
+		if strings.EqualFold(name, c.String()) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("nbt: Unknown compression %q", name)
+}
+
+// Guesses how a document is compressed from its first two bytes, which for
+// GZip and ZLib are magic numbers, and otherwise takes it to be uncompressed.
+// An uncompressed document with a TAG_Compound root, as nearly all are, never
+// looks compressed, but other roots can: a TAG_String root whose name is
+// 0x1Dxx bytes long looks like ZLib. Give the compression explicitly for
+// documents like that.
+func DetectCompression(header []byte) Compression {
+	switch {
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
+		return GZip
+	case len(header) >= 2 && header[0]&0x0f == 8 && (uint(header[0])<<8|uint(header[1]))%31 == 0:
+		return ZLib
+	}
+	return Uncompressed
+}
+
+// A Dialect is one of the variants of the binary format. Tags and their layout
+// are the same in all of them; what differs is how numbers and strings are
+// written.
+type Dialect byte
+
+const (
+	Java    Dialect = iota // Big endian, strings in Java's modified UTF-8.
+	Bedrock                // Little endian, strings in standard UTF-8.
+
+	// Bedrock's network format: like Bedrock, but TAG_Int and TAG_Long
+	// payloads, the elements of int and long arrays, and the lengths of lists
+	// and arrays are zigzag varints, and string lengths are unsigned varints.
+	BedrockNetwork
+)
+
+func (dialect Dialect) String() string {
+	switch dialect {
+	case Java:
+		return "Java"
+	case Bedrock:
+		return "Bedrock"
+	case BedrockNetwork:
+		return "BedrockNetwork"
+	}
+	return fmt.Sprintf("Dialect(%d)", byte(dialect))
+}
+
+// Returns the dialect with the given name, ignoring case, dashes and
+// underscores, so that "bedrock-network" names BedrockNetwork.
+func ParseDialect(name string) (Dialect, error) {
+	bare := strings.NewReplacer("-", "", "_", "").Replace(name)
+	for d := Java; d <= BedrockNetwork; d++ {
+		if strings.EqualFold(bare, d.String()) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("nbt: Unknown dialect %q", name)
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+func (dialect Dialect) byteOrder() byteOrder {
+	switch dialect {
+	case Java:
+		return binary.BigEndian
+	case Bedrock, BedrockNetwork:
+		return binary.LittleEndian
+	}
+	panic(fmt.Errorf("nbt: Unknown dialect: %d", dialect))
+}
@@ -0,0 +1,107 @@
+package nbt
+
+import (
+	"fmt"
+	"io"
+)
+
+// Reads a document in dec's dialect and writes it in enc's, keeping the root
+// name and the order of compound entries. The compression of each side is
+// given separately, so this also recompresses documents. Strings and lists
+// too long for enc are handled as for Marshal.
+func (enc *Encoder) Transcode(compression Compression, out io.Writer, dec *Decoder, inCompression Compression, in io.Reader) (err error) {
+	defer recoverError(&err)
+
+	if compression > ZLib {
+		panic(fmt.Errorf("nbt: Unknown compression type: %d", compression))
+	}
+
+	d := newDecodeState(dec).init(inCompression, in)
+	e := encodeState{Encoder: enc, order: enc.Dialect.byteOrder()}
+	name, tag := d.readTag()
+	e.path = append(e.path, pathElem{name, -1})
+	e.writeU8(byte(tag))
+	e.writeString(name)
+	if tag != TagEnd {
+		transcode(d, &e, tag)
+	}
+
+	return writeCompressed(compression, out, e.buf)
+}
+
+func transcode(d *decodeState, e *encodeState, tag Tag) {
+	switch tag {
+	case TagByte:
+		e.writeU8(d.readU8())
+	case TagShort:
+		e.writeU16(d.readU16())
+	case TagInt:
+		e.writeInt32(d.readInt32())
+	case TagLong:
+		e.writeInt64(d.readInt64())
+	case TagFloat:
+		e.writeU32(d.readU32())
+	case TagDouble:
+		e.writeU64(d.readU64())
+	case TagString:
+		e.writeString(d.readString())
+
+	case TagByteArray:
+		length := d.readLen()
+		n := e.checkLength(tag, length, maxListLength)
+		e.writeLen(n)
+		e.buf = append(e.buf, d.next(length)[:n]...)
+
+	case TagIntArray, TagLongArray, TagList:
+		inner := TagInt
+		switch tag {
+		case TagLongArray:
+			inner = TagLong
+		case TagList:
+			inner = Tag(d.readU8())
+			e.writeU8(byte(inner))
+		}
+		length := d.readLen()
+		n := e.checkLength(tag, length, maxListLength)
+		e.writeLen(n)
+
+		var i int
+		defer func() {
+			if r := recover(); r != nil {
+				panic(annotate(r, "\n\t\tat list index %d", i))
+			}
+		}()
+		for i = 0; i < length; i++ {
+			if i >= n {
+				d.skip(inner)
+				continue
+			}
+			e.path = append(e.path, pathElem{index: i})
+			transcode(d, e, inner)
+			e.path = e.path[:len(e.path)-1]
+		}
+
+	case TagCompound:
+		var name string
+		defer func() {
+			if r := recover(); r != nil {
+				panic(annotate(r, "\n\t\tat struct field %#v", name))
+			}
+		}()
+		for {
+			var tag Tag
+			name, tag = d.readTag()
+			e.writeU8(byte(tag))
+			if tag == TagEnd {
+				break
+			}
+			e.path = append(e.path, pathElem{name, -1})
+			e.writeString(name)
+			transcode(d, e, tag)
+			e.path = e.path[:len(e.path)-1]
+		}
+
+	default:
+		panic(fmt.Errorf("nbt: Unhandled tag: %s", tag))
+	}
+}
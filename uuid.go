@@ -0,0 +1,70 @@
+package nbt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A UUID as used for entities and players. Since Minecraft 1.16 it is stored
+// as a TAG_Int_Array of four ints, most significant first; before that it was
+// split into two TAG_Longs whose names end in Most and Least, which a struct
+// field can decode from and, with the mostleast option, encode to.
+type UUID [16]byte
+
+// Formats the UUID in the usual hyphenated form.
+func (id UUID) String() string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// Parses a UUID in the hyphenated form, or without hyphens.
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("nbt: Invalid UUID %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// The length of a game tick, for time.Duration fields with the ticks option.
+const Tick = 50 * time.Millisecond
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	uuidType     = reflect.TypeOf(UUID{})
+	uuidPtrType  = reflect.PointerTo(uuidType)
+)
+
+// Returns the unit a time.Duration field is stored in, or 0 if it is stored as
+// plain nanoseconds.
+func durationUnit(opts tagOptions) time.Duration {
+	switch {
+	case opts.has("ticks"):
+		return Tick
+	case opts.has("millis"):
+		return time.Millisecond
+	}
+	return 0
+}
+
+// For a compound entry name like "UUIDMost", returns the UUID or *UUID field
+// it is half of and whether it is the low half.
+func (fields *structFields) uuidHalf(name string) (*field, bool, bool) {
+	prefix, low := strings.CutSuffix(name, "Least")
+	if !low {
+		var ok bool
+		if prefix, ok = strings.CutSuffix(name, "Most"); !ok {
+			return nil, false, false
+		}
+	}
+	if f, ok := fields.named(prefix); ok && (f.typ == uuidType || f.typ == uuidPtrType) {
+		return f, low, true
+	}
+	return nil, false, false
+}